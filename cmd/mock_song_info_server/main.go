@@ -2,12 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand/v2"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/Onlymiind/test_task/internal/database"
@@ -19,7 +21,26 @@ type response struct {
 	URL         string `json:"url"`
 }
 
+// flags used to simulate a slow or flaky upstream
+var (
+	address     = flag.String("address", ":7070", "TCP address to listen on")
+	delay       = flag.Duration("delay", 0, "delay before every response")
+	fail_rate   = flag.Float64("fail-rate", 0, "probability of responding with -fail-status, from 0 to 1")
+	fail_first  = flag.Uint64("fail-first", 0, "number of first requests to fail with -fail-status")
+	fail_status = flag.Int("fail-status", http.StatusServiceUnavailable, "status code of failed responses")
+
+	request_count atomic.Uint64
+)
+
 func generateSongInfo(writer http.ResponseWriter, _ *http.Request) {
+	request_idx := request_count.Add(1)
+	time.Sleep(*delay)
+	if request_idx <= *fail_first || rand.Float64() < *fail_rate {
+		log.Println("request ", request_idx, ": simulating failure")
+		writer.WriteHeader(*fail_status)
+		return
+	}
+
 	result := response{}
 	verse_count := rand.UintN(11) + 1
 	date := time.Date(rand.IntN(100)+1950, time.Month(rand.IntN(11)+1), rand.IntN(28)+1, 0, 0, 0, 0, time.Local)
@@ -44,6 +65,7 @@ func generateSongInfo(writer http.ResponseWriter, _ *http.Request) {
 }
 
 func main() {
+	flag.Parse()
 	http.HandleFunc("/info", generateSongInfo)
-	log.Fatal(http.ListenAndServe(":7070", nil))
+	log.Fatal(http.ListenAndServe(*address, nil))
 }
@@ -1,14 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Onlymiind/test_task/internal/auth"
 	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/importer"
+	"github.com/Onlymiind/test_task/internal/ingest"
 	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/purge"
 	"github.com/Onlymiind/test_task/internal/server"
+	"github.com/Onlymiind/test_task/internal/songinfo"
+	"github.com/Onlymiind/test_task/internal/tracing"
 	"github.com/joho/godotenv"
 )
 
@@ -22,61 +38,606 @@ const (
 	db_port_key            = "DB_PORT"
 	db_name_key            = "DB_NAME"
 	db_migrations_path_key = "DB_MIGRATIONS_PATH"
+	db_min_conns_key       = "DB_MIN_CONNS"
+	db_max_conns_key       = "DB_MAX_CONNS"
+	db_max_idle_time_key   = "DB_MAX_CONN_IDLE_TIME"
+	db_health_check_key    = "DB_HEALTH_CHECK_PERIOD"
+	db_query_timeout_key   = "DB_QUERY_TIMEOUT"
 	log_file_key           = "LOG_FILE"
+	log_format_key         = "LOG_FORMAT"
+	log_level_key          = "LOG_LEVEL"
+	log_max_size_key       = "LOG_MAX_SIZE_MB"
+	log_interval_key       = "LOG_ROTATE_INTERVAL"
+	log_max_age_key        = "LOG_MAX_AGE_DAYS"
+	log_max_backups_key    = "LOG_MAX_BACKUPS"
+	log_compress_key       = "LOG_COMPRESS"
 	song_info_url_key      = "SONG_INFO_URL"
+	song_info_timeout_key  = "SONG_INFO_TIMEOUT"
+	song_info_retries_key  = "SONG_INFO_RETRIES"
+	song_info_backoff_key  = "SONG_INFO_BACKOFF"
+	song_info_max_key      = "SONG_INFO_MAX_BACKOFF"
+	song_info_breaker_key  = "SONG_INFO_BREAKER_THRESHOLD"
+	song_info_cooldown_key = "SONG_INFO_BREAKER_COOLDOWN"
 	address_key            = "ADDRESS"
+	storage_key            = "STORAGE"
+	ingest_workers_key     = "INGEST_WORKERS"
+	ingest_queue_size_key  = "INGEST_QUEUE_SIZE"
+	import_batch_size_key  = "IMPORT_BATCH_SIZE"
+	trash_retention_key    = "TRASH_RETENTION_DAYS"
+	trash_purge_key        = "TRASH_PURGE_INTERVAL"
+	auth_enabled_key       = "AUTH_ENABLED"
+	jwt_key_file_key       = "JWT_KEY_FILE"
+	jwt_issuer_key         = "JWT_ISSUER"
+	jwt_audience_key       = "JWT_AUDIENCE"
+	jwt_role_claim_key     = "JWT_ROLE_CLAIM"
+	tracing_exporter_key   = "TRACING_EXPORTER"
+	tracing_endpoint_key   = "TRACING_OTLP_ENDPOINT"
+	tracing_file_key       = "TRACING_FILE"
+	shutdown_timeout_key   = "SHUTDOWN_TIMEOUT"
+	ready_song_info_key    = "READY_CHECK_SONG_INFO"
+
+	default_shutdown_timeout = 30 * time.Second
+
+	memory_storage = "memory"
+	import_command = "import"
+	keys_command   = "keys"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == import_command {
+		os.Exit(runImport(os.Args[2:]))
+	} else if len(os.Args) > 1 && os.Args[1] == keys_command {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+
 	env_file_path := default_env_file_path
 	if len(os.Args) > 1 {
 		env_file_path = os.Args[1]
 	}
+	env := readEnv(env_file_path)
+	log_file := openLogFile(env)
+	defer log_file.Close()
+
+	logger := newLogger(env, log_file)
+	defer reopenOnSIGHUP(log_file, logger)()
+
+	shutdown_tracing, err := tracing.Init(tracing.Config{
+		Exporter:     env[tracing_exporter_key],
+		OTLPEndpoint: env[tracing_endpoint_key],
+		File:         env[tracing_file_key],
+	}, logger)
+	if err != nil {
+		return
+	}
+	defer shutdown_tracing(context.Background())
+
+	shutdown_timeout, err := readDuration(env, shutdown_timeout_key)
+	if err != nil {
+		logger.Error("failed to get shutdown timeout: ", err.Error())
+		return
+	} else if shutdown_timeout == 0 {
+		shutdown_timeout = default_shutdown_timeout
+	}
+	check_song_info := false
+	if env[ready_song_info_key] != "" {
+		if check_song_info, err = strconv.ParseBool(env[ready_song_info_key]); err != nil {
+			logger.Error("failed to parse ", ready_song_info_key, ": ", err.Error())
+			return
+		}
+	}
+
+	repository, close_db := initRepository(env, logger)
+	if repository == nil {
+		return
+	}
+	defer close_db()
+
+	keys, tokens, success := initAuth(env, repository, logger)
+	if !success {
+		return
+	}
+
+	song_info_config, success := readSongInfoConfig(env, logger)
+	if !success {
+		return
+	}
+	song_info := songinfo.NewClient(song_info_config, logger)
+
+	song_importer, success := newImporter(env, repository, song_info, logger)
+	if !success {
+		return
+	}
+
+	purger, success := newPurger(env, repository, logger)
+	if !success {
+		return
+	}
+
+	var workers, queue_size uint64
+	if env[ingest_workers_key] != "" {
+		workers, err = strconv.ParseUint(env[ingest_workers_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get ingestion worker count: ", err.Error())
+			return
+		}
+	}
+	if env[ingest_queue_size_key] != "" {
+		queue_size, err = strconv.ParseUint(env[ingest_queue_size_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get ingestion queue size: ", err.Error())
+			return
+		}
+	}
+	ingestion := ingest.NewPool(uint(workers), uint(queue_size), repository, repository, song_info, logger)
+	if err = ingestion.Start(); err != nil {
+		logger.Error("failed to start ingestion workers")
+		return
+	}
+	purger.Start()
+
+	server.Init(repository, song_info, ingestion, song_importer, keys, tokens, check_song_info, logger)
+	serve(&http.Server{Addr: env[address_key]}, shutdown_timeout, logger)
+
+	// the HTTP server doesn't accept new requests anymore, so nothing can submit new jobs
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown_timeout)
+	defer cancel()
+	ingestion.Stop(ctx)
+	purger.Stop(ctx)
+	logger.Info("shutdown complete")
+}
+
+// serve runs the HTTP server until it fails or SIGINT or SIGTERM is received. On a signal the
+// server stops accepting connections and waits for in-flight requests until the timeout expires.
+func serve(http_server *http.Server, shutdown_timeout time.Duration, logger *logger.Logger) {
+	signal_ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serve_err := make(chan error, 1)
+	go func() {
+		serve_err <- http_server.ListenAndServe()
+	}()
+	logger.Info("listening on ", http_server.Addr)
+
+	select {
+	case err := <-serve_err:
+		logger.Error("server stopped: ", err.Error())
+		return
+	case <-signal_ctx.Done():
+	}
+	// a second signal kills the process immediately
+	stop()
+
+	logger.Info("shutting down, waiting up to ", shutdown_timeout, " for in-flight requests")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdown_timeout)
+	defer cancel()
+	if err := http_server.Shutdown(ctx); err != nil {
+		logger.Error("failed to finish in-flight requests: ", err.Error())
+		http_server.Close()
+	}
+}
+
+func newPurger(env map[string]string, trash database.TrashRepository, logger *logger.Logger) (*purge.Purger, bool) {
+	var retention time.Duration
+	if env[trash_retention_key] != "" {
+		days, err := strconv.ParseUint(env[trash_retention_key], 10, 16)
+		if err != nil || days == 0 {
+			logger.Error("failed to get trash retention period: expected a positive number of days, got '", env[trash_retention_key], "'")
+			return nil, false
+		}
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	interval, err := readDuration(env, trash_purge_key)
+	if err != nil {
+		logger.Error("failed to get trash purge interval: ", err.Error())
+		return nil, false
+	}
+	return purge.NewPurger(trash, retention, interval, logger), true
+}
+
+// runImport implements the import subcommand and returns the exit code.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet(import_command, flag.ExitOnError)
+	env_file_path := flags.String("env", default_env_file_path, "path to the .env file")
+	format := flags.String("format", "", "input format: csv or jsonl, detected from the file extension by default")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: server import [-env path] [-format csv|jsonl] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	input_path := flags.Arg(0)
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(input_path)) {
+		case ".csv":
+			*format = importer.FormatCSV
+		case ".jsonl", ".ndjson":
+			*format = importer.FormatJSONL
+		default:
+			fmt.Fprintf(os.Stderr, "unable to detect the format of %s, use -format\n", input_path)
+			return 2
+		}
+	}
+
+	env := readEnv(*env_file_path)
+	log_file := openLogFile(env)
+	defer log_file.Close()
+	logger := newLogger(env, log_file)
+
+	input, err := os.Open(input_path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %s\n", input_path, err.Error())
+		return 1
+	}
+	defer input.Close()
+
+	repository, close_db := initRepository(env, logger)
+	if repository == nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize the storage, see the log for details")
+		return 1
+	}
+	defer close_db()
+
+	song_info_config, success := readSongInfoConfig(env, logger)
+	if !success {
+		fmt.Fprintln(os.Stderr, "invalid song info configuration, see the log for details")
+		return 1
+	}
+	song_importer, success := newImporter(env, repository, songinfo.NewClient(song_info_config, logger), logger)
+	if !success {
+		fmt.Fprintln(os.Stderr, "invalid import configuration, see the log for details")
+		return 1
+	}
+
+	report, err := song_importer.Import(context.Background(), input, *format)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %s\n", err.Error())
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "inserted: %d, skipped: %d, failed: %d\n", report.Inserted, report.Skipped, report.Failed)
+	return 0
+}
+
+func readEnv(env_file_path string) map[string]string {
 	env, err := godotenv.Read(env_file_path)
 	if err != nil {
 		log.Fatal("failed to read the .env file ", env_file_path, ": ", err.Error())
 	}
+	return env
+}
+
+// openLogFile opens LOG_FILE with the rotation configured by the LOG_* variables.
+func openLogFile(env map[string]string) *logger.File {
+	config := logger.FileConfig{Path: env[log_file_key]}
+	if config.Path == "" {
+		config.Path = default_log_file_path
+	}
+	if env[log_max_size_key] != "" {
+		size, err := strconv.ParseUint(env[log_max_size_key], 10, 32)
+		if err != nil {
+			log.Fatal("failed to get maximum log file size: ", err.Error())
+		}
+		config.MaxSize = int64(size) << 20
+	}
+	var err error
+	if config.Interval, err = readDuration(env, log_interval_key); err != nil {
+		log.Fatal("failed to get log rotation interval: ", err.Error())
+	}
+	if env[log_max_age_key] != "" {
+		days, err := strconv.ParseUint(env[log_max_age_key], 10, 16)
+		if err != nil {
+			log.Fatal("failed to get log retention period: ", err.Error())
+		}
+		config.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	if env[log_max_backups_key] != "" {
+		backups, err := strconv.ParseUint(env[log_max_backups_key], 10, 16)
+		if err != nil {
+			log.Fatal("failed to get maximum rotated log file count: ", err.Error())
+		}
+		config.MaxBackups = int(backups)
+	}
+	if env[log_compress_key] != "" {
+		if config.Compress, err = strconv.ParseBool(env[log_compress_key]); err != nil {
+			log.Fatal("failed to parse ", log_compress_key, ": ", err.Error())
+		}
+	}
+
+	log_file, err := logger.OpenFile(config)
+	if err != nil {
+		log.Fatal("failed to open log file ", config.Path, ": ", err.Error())
+	}
+	return log_file
+}
+
+// reopenOnSIGHUP reopens the log file on SIGHUP, after it was moved by an external tool
+// such as logrotate. The returned function stops handling the signal.
+func reopenOnSIGHUP(log_file *logger.File, logger *logger.Logger) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := log_file.Reopen(); err != nil {
+					fmt.Fprintln(os.Stderr, "failed to reopen log file:", err.Error())
+				} else {
+					logger.Info("log file reopened")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// newLogger creates the logger configured by LOG_FORMAT and LOG_LEVEL.
+func newLogger(env map[string]string, out io.Writer) *logger.Logger {
+	result, err := logger.NewLogger(out, logger.Config{Format: env[log_format_key], Level: env[log_level_key]})
+	if err != nil {
+		log.Fatal("failed to create the logger: ", err.Error())
+	}
+	return result
+}
+
+// storage is implemented by both database.Db and database.MemoryDb.
+type storage interface {
+	database.SongRepository
+	database.JobRepository
+	database.KeyRepository
+}
 
-	log_file_path := env[log_file_key]
-	if log_file_path == "" {
-		log_file_path = default_log_file_path
+// initRepository creates the storage selected by the configuration. The returned
+// function releases it. The storage is nil if it could not be created.
+func initRepository(env map[string]string, logger *logger.Logger) (storage, func()) {
+	if env[storage_key] == memory_storage {
+		logger.Info("using in-memory storage")
+		return database.NewMemoryDb(logger), func() {}
+	}
+	db := initDb(env, logger)
+	if db == nil {
+		logger.Error("failed to connect to the database")
+		return nil, nil
+	}
+	return db, db.Close
+}
+
+// initAuth returns the API key service and the JWT validator, both are nil if authentication
+// is disabled. JWT validation is enabled only if a key file is configured.
+func initAuth(env map[string]string, repository storage, logger *logger.Logger) (*auth.Keys, *auth.Tokens, bool) {
+	if env[auth_enabled_key] != "" {
+		enabled, err := strconv.ParseBool(env[auth_enabled_key])
+		if err != nil {
+			logger.Error("failed to parse ", auth_enabled_key, ": ", err.Error())
+			return nil, nil, false
+		} else if !enabled {
+			logger.Info("authentication is disabled")
+			return nil, nil, true
+		}
+	}
+	keys := auth.NewKeys(repository, logger)
+	if env[storage_key] == memory_storage {
+		// keys issued by the CLI don't reach the in-memory storage of a running server
+		key, secret, err := keys.Issue(context.Background(), "admin", database.RoleAdmin)
+		if err != nil {
+			return nil, nil, false
+		}
+		fmt.Fprintf(os.Stderr, "in-memory storage: issued admin API key %s: %s\n", key.ID, secret)
 	}
 
-	log_file, err := os.OpenFile(log_file_path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0777)
+	if env[jwt_key_file_key] == "" {
+		return keys, nil, true
+	}
+	tokens, err := auth.LoadTokens(auth.TokenConfig{
+		KeyFile:   env[jwt_key_file_key],
+		Issuer:    env[jwt_issuer_key],
+		Audience:  env[jwt_audience_key],
+		RoleClaim: env[jwt_role_claim_key],
+	}, logger)
 	if err != nil {
-		log.Fatal("failed to open log file ", log_file_path, ": ", err.Error())
+		return nil, nil, false
+	}
+	return keys, tokens, true
+}
+
+// runKeys implements the keys subcommand and returns the exit code.
+func runKeys(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: server keys issue [-env path] -name <name> -role reader|editor|admin")
+		fmt.Fprintln(os.Stderr, "       server keys list [-env path]")
+		fmt.Fprintln(os.Stderr, "       server keys revoke [-env path] <id>")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
 	}
+	flags := flag.NewFlagSet(keys_command+" "+args[0], flag.ExitOnError)
+	env_file_path := flags.String("env", default_env_file_path, "path to the .env file")
+	name := flags.String("name", "", "name of the key owner, recorded as the author of changes")
+	role := flags.String("role", string(database.RoleReader), "role of the key: reader, editor or admin")
+	flags.Usage = usage
+	flags.Parse(args[1:])
+
+	env := readEnv(*env_file_path)
+	log_file := openLogFile(env)
 	defer log_file.Close()
+	logger := newLogger(env, log_file)
 
-	logger := logger.NewLogger(log_file)
+	repository, close_db := initRepository(env, logger)
+	if repository == nil {
+		fmt.Fprintln(os.Stderr, "failed to initialize the storage, see the log for details")
+		return 1
+	}
+	defer close_db()
+	keys := auth.NewKeys(repository, logger)
 
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	switch args[0] {
+	case "issue":
+		key_role, err := auth.ParseRole(*role)
+		if err != nil || *name == "" || flags.NArg() != 0 {
+			usage()
+			return 2
+		}
+		key, secret, err := keys.Issue(context.Background(), *name, key_role)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to issue the key: %s\n", err.Error())
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "issued key %s for '%s', role %s; store it now, it can't be shown again\n", key.ID, key.Name, key.Role)
+		fmt.Println(secret)
+	case "list":
+		list, err := keys.List(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list the keys: %s\n", err.Error())
+			return 1
+		}
+		encoder.Encode(list)
+	case "revoke":
+		if flags.NArg() != 1 {
+			usage()
+			return 2
+		}
+		if err := keys.Revoke(context.Background(), flags.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to revoke the key: %s\n", err.Error())
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "key %s revoked\n", flags.Arg(0))
+	default:
+		usage()
+		return 2
+	}
+	return 0
+}
+
+func newImporter(env map[string]string, repository database.SongRepository, song_info *songinfo.Client,
+	logger *logger.Logger) (*importer.Importer, bool) {
+	var batch_size uint64
+	if env[import_batch_size_key] != "" {
+		var err error
+		batch_size, err = strconv.ParseUint(env[import_batch_size_key], 10, 31)
+		if err != nil {
+			logger.Error("failed to get import batch size: ", err.Error())
+			return nil, false
+		}
+	}
+	return importer.NewImporter(repository, song_info, int(batch_size), logger), true
+}
+
+func initDb(env map[string]string, logger *logger.Logger) *database.Db {
+	var err error
 	var db_port uint64 = 0
 	if env[db_port_key] != "" {
 		db_port, err = strconv.ParseUint(env[db_port_key], 10, 16)
 		if err != nil {
 			logger.Error("failed to get port number: ", err.Error())
-			return
+			return nil
 		}
 	}
 	if env[db_name_key] == "" {
 		logger.Error("database name must be non-empty")
-		return
+		return nil
 	} else if env[db_user_key] == "" {
 		logger.Error("database user must be non-empty")
-		return
+		return nil
 	} else if env[db_host_key] == "" {
 		logger.Error("database host must be non-empty")
-		return
+		return nil
 	}
 
-	db := database.Init(env[db_user_key], env[db_password_key],
-		env[db_host_key], uint16(db_port), env[db_name_key], env[db_migrations_path_key], logger)
-	if db == nil {
-		logger.Error("failed to connect to the database")
-		return
+	pool_config := database.PoolConfig{}
+	if env[db_min_conns_key] != "" {
+		min_conns, err := strconv.ParseInt(env[db_min_conns_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get minimum connection count: ", err.Error())
+			return nil
+		}
+		pool_config.MinConns = int32(min_conns)
 	}
-	defer db.Close()
+	if env[db_max_conns_key] != "" {
+		max_conns, err := strconv.ParseInt(env[db_max_conns_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get maximum connection count: ", err.Error())
+			return nil
+		}
+		pool_config.MaxConns = int32(max_conns)
+	}
+	if pool_config.MaxConnIdleTime, err = readDuration(env, db_max_idle_time_key); err != nil {
+		logger.Error("failed to get connection idle timeout: ", err.Error())
+		return nil
+	}
+	if pool_config.HealthCheckPeriod, err = readDuration(env, db_health_check_key); err != nil {
+		logger.Error("failed to get health check period: ", err.Error())
+		return nil
+	}
+	if pool_config.QueryTimeout, err = readDuration(env, db_query_timeout_key); err != nil {
+		logger.Error("failed to get query timeout: ", err.Error())
+		return nil
+	}
+
+	return database.Init(env[db_user_key], env[db_password_key],
+		env[db_host_key], uint16(db_port), env[db_name_key], env[db_migrations_path_key], pool_config, logger)
+}
+
+// readDuration parses an optional duration setting, returning zero if it is not set.
+func readDuration(env map[string]string, key string) (time.Duration, error) {
+	if env[key] == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(env[key])
+	if err != nil {
+		return 0, err
+	} else if duration <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %s", key, env[key])
+	}
+	return duration, nil
+}
 
-	server.Init(db, env[song_info_url_key], logger)
-	logger.Info(http.ListenAndServe(env[address_key], nil).Error())
+func readSongInfoConfig(env map[string]string, logger *logger.Logger) (songinfo.Config, bool) {
+	var err error
+	config := songinfo.Config{URL: env[song_info_url_key], MaxRetries: songinfo.DefaultMaxRetries}
+	if config.Timeout, err = readDuration(env, song_info_timeout_key); err != nil {
+		logger.Error("failed to get song info timeout: ", err.Error())
+		return songinfo.Config{}, false
+	}
+	if config.BaseBackoff, err = readDuration(env, song_info_backoff_key); err != nil {
+		logger.Error("failed to get song info retry backoff: ", err.Error())
+		return songinfo.Config{}, false
+	}
+	if config.MaxBackoff, err = readDuration(env, song_info_max_key); err != nil {
+		logger.Error("failed to get song info maximum retry backoff: ", err.Error())
+		return songinfo.Config{}, false
+	}
+	if config.BreakerCooldown, err = readDuration(env, song_info_cooldown_key); err != nil {
+		logger.Error("failed to get song info circuit breaker cooldown: ", err.Error())
+		return songinfo.Config{}, false
+	}
+	if env[song_info_retries_key] != "" {
+		retries, err := strconv.ParseUint(env[song_info_retries_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get song info retry count: ", err.Error())
+			return songinfo.Config{}, false
+		}
+		config.MaxRetries = uint(retries)
+	}
+	if env[song_info_breaker_key] != "" {
+		threshold, err := strconv.ParseUint(env[song_info_breaker_key], 10, 32)
+		if err != nil {
+			logger.Error("failed to get song info circuit breaker threshold: ", err.Error())
+			return songinfo.Config{}, false
+		}
+		config.BreakerThreshold = uint(threshold)
+	}
+	return config, true
 }
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+var (
+	ErrUnauthenticated = fmt.Errorf("missing or invalid credentials")
+	ErrForbidden       = fmt.Errorf("insufficient role")
+	ErrInvalidRole     = fmt.Errorf("unknown role")
+)
+
+// role_ranks orders roles, every role is allowed to do what the lower ones can
+var role_ranks = map[database.Role]int{
+	database.RoleReader: 1,
+	database.RoleEditor: 2,
+	database.RoleAdmin:  3,
+}
+
+func ParseRole(role string) (database.Role, error) {
+	if _, found := role_ranks[database.Role(role)]; !found {
+		return "", ErrInvalidRole
+	}
+	return database.Role(role), nil
+}
+
+// Allows reports whether role grants the permissions of required.
+func Allows(role, required database.Role) bool {
+	rank, found := role_ranks[role]
+	return found && rank >= role_ranks[required]
+}
+
+// Identity is the authenticated caller.
+type Identity struct {
+	Name string
+	Role database.Role
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity stored by WithIdentity.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, found := ctx.Value(identityKey{}).(Identity)
+	return identity, found
+}
+
+// Keys issues and verifies API keys. Keys are random 32 byte values, only their SHA-256
+// hashes are stored, so a lost key can't be recovered, only revoked and reissued.
+type Keys struct {
+	keys   database.KeyRepository
+	logger *logger.Logger
+}
+
+func NewKeys(keys database.KeyRepository, logger *logger.Logger) *Keys {
+	return &Keys{keys: keys, logger: logger}
+}
+
+func hashKey(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(size int) string {
+	result := make([]byte, size)
+	rand.Read(result)
+	return hex.EncodeToString(result)
+}
+
+// Issue creates a key and returns its description and the key itself.
+func (k *Keys) Issue(ctx context.Context, name string, role database.Role) (database.APIKey, string, error) {
+	log := logger.FromContext(ctx, k.logger)
+	if name == "" {
+		log.Error("invalid use of Issue: key name is empty")
+		return database.APIKey{}, "", database.ErrInvalidData
+	} else if _, err := ParseRole(string(role)); err != nil {
+		log.Error(err.Error(), ": '", role, "'")
+		return database.APIKey{}, "", err
+	}
+	key := database.APIKey{
+		ID:        randomHex(8),
+		Name:      name,
+		Role:      role,
+		CreatedAt: time.Now(),
+	}
+	secret := randomHex(32)
+	if err := k.keys.AddKey(ctx, key, hashKey(secret)); err != nil {
+		return database.APIKey{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Authenticate returns the identity of the key owner.
+func (k *Keys) Authenticate(ctx context.Context, secret string) (Identity, error) {
+	if secret == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	key, err := k.keys.GetKeyByHash(ctx, hashKey(secret))
+	if err == database.ErrKeyNotFound {
+		return Identity{}, ErrUnauthenticated
+	} else if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Name: key.Name, Role: key.Role}, nil
+}
+
+func (k *Keys) List(ctx context.Context) ([]database.APIKey, error) {
+	return k.keys.GetKeys(ctx)
+}
+
+func (k *Keys) Revoke(ctx context.Context, id string) error {
+	return k.keys.RevokeKey(ctx, id)
+}
@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+const (
+	DefaultRoleClaim = "role"
+
+	alg_hs256 = "HS256"
+	alg_rs256 = "RS256"
+	alg_eddsa = "EdDSA"
+
+	// allowed difference between the local clock and the token issuer clock
+	clock_skew = 30 * time.Second
+	// RFC 7518 requires HMAC keys to be at least as long as the hash output
+	min_hmac_key_size = sha256.Size
+)
+
+var (
+	ErrNoKeys       = fmt.Errorf("no usable keys in the key file")
+	ErrWeakHMACKey  = fmt.Errorf("HMAC key must be at least 32 bytes long")
+	ErrTokenInvalid = fmt.Errorf("invalid token")
+)
+
+// TokenConfig describes how bearer tokens are validated. Issuer and Audience are checked only if set.
+type TokenConfig struct {
+	// KeyFile is a JWKS (or a single JWK) JSON file, a PEM file with public keys or
+	// certificates, or a file containing a raw HMAC secret.
+	KeyFile  string
+	Issuer   string
+	Audience string
+	// RoleClaim is the claim holding the role name or a list of role names, the highest role is used.
+	RoleClaim string
+}
+
+type verificationKey struct {
+	id  string
+	alg string
+	// []byte for HMAC, *rsa.PublicKey or ed25519.PublicKey
+	key any
+}
+
+// accepts reports whether the key can verify tokens signed with alg. Key types are
+// bound to algorithms, so that e.g. a public RSA key can't be used as an HMAC secret.
+func (k verificationKey) accepts(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch k.key.(type) {
+	case []byte:
+		return alg == alg_hs256
+	case *rsa.PublicKey:
+		return alg == alg_rs256
+	case ed25519.PublicKey:
+		return alg == alg_eddsa
+	}
+	return false
+}
+
+func (k verificationKey) verify(alg string, input, signature []byte) bool {
+	switch alg {
+	case alg_hs256:
+		mac := hmac.New(sha256.New, k.key.([]byte))
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case alg_rs256:
+		hash := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	case alg_eddsa:
+		return ed25519.Verify(k.key.(ed25519.PublicKey), input, signature)
+	}
+	return false
+}
+
+// Tokens validates JWT bearer tokens signed with HS256, RS256 or EdDSA using keys loaded
+// from a local file. Nothing is fetched over the network.
+type Tokens struct {
+	keys       []verificationKey
+	issuer     string
+	audience   string
+	role_claim string
+	logger     *logger.Logger
+}
+
+func LoadTokens(config TokenConfig, logger *logger.Logger) (*Tokens, error) {
+	content, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		logger.Error("failed to read JWT key file: ", err.Error())
+		return nil, err
+	}
+	keys, err := parseKeys(content)
+	if err != nil {
+		logger.Error("failed to load JWT keys from ", config.KeyFile, ": ", err.Error())
+		return nil, err
+	}
+	if config.RoleClaim == "" {
+		config.RoleClaim = DefaultRoleClaim
+	}
+	logger.Info("loaded ", len(keys), " JWT verification keys from ", config.KeyFile)
+	return &Tokens{
+		keys:       keys,
+		issuer:     config.Issuer,
+		audience:   config.Audience,
+		role_claim: config.RoleClaim,
+		logger:     logger,
+	}, nil
+}
+
+func parseKeys(content []byte) ([]verificationKey, error) {
+	trimmed := bytes.TrimSpace(content)
+	var keys []verificationKey
+	var err error
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		keys, err = parseJWKS(trimmed)
+	} else if block, _ := pem.Decode(trimmed); block != nil {
+		keys, err = parsePEM(trimmed)
+	} else if len(trimmed) < min_hmac_key_size {
+		return nil, ErrWeakHMACKey
+	} else {
+		keys = []verificationKey{{key: trimmed}}
+	}
+	if err == nil && len(keys) == 0 {
+		err = ErrNoKeys
+	}
+	return keys, err
+}
+
+func parsePEM(content []byte) ([]verificationKey, error) {
+	var keys []verificationKey
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var certificate *x509.Certificate
+			if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = certificate.PublicKey
+			}
+		default:
+			// private keys and other blocks are not used for verification
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, ed25519.PublicKey:
+			keys = append(keys, verificationKey{key: key})
+		}
+	}
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+func parseJWKS(content []byte) ([]verificationKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+	if set.Keys == nil {
+		// a single key instead of a key set
+		single := jsonWebKey{}
+		if err := json.Unmarshal(content, &single); err != nil {
+			return nil, err
+		}
+		set.Keys = []jsonWebKey{single}
+	}
+
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for _, web_key := range set.Keys {
+		if web_key.Use != "" && web_key.Use != "sig" {
+			continue
+		}
+		result := verificationKey{id: web_key.Kid, alg: web_key.Alg}
+		switch web_key.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(web_key.K)
+			if err != nil {
+				return nil, err
+			} else if len(secret) < min_hmac_key_size {
+				return nil, ErrWeakHMACKey
+			}
+			result.key = secret
+		case "RSA":
+			modulus, err := base64.RawURLEncoding.DecodeString(web_key.N)
+			if err != nil {
+				return nil, err
+			}
+			exponent, err := base64.RawURLEncoding.DecodeString(web_key.E)
+			if err != nil {
+				return nil, err
+			}
+			result.key = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+		case "OKP":
+			if web_key.Crv != "Ed25519" {
+				continue
+			}
+			public, err := base64.RawURLEncoding.DecodeString(web_key.X)
+			if err != nil {
+				return nil, err
+			} else if len(public) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("invalid Ed25519 key size %d", len(public))
+			}
+			result.key = ed25519.PublicKey(public)
+		default:
+			continue
+		}
+		keys = append(keys, result)
+	}
+	return keys, nil
+}
+
+// IsToken reports whether the credential looks like a JWT rather than an API key.
+func IsToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// Verify checks the token signature and claims and returns the identity of its subject.
+func (t *Tokens) Verify(token string) (Identity, error) {
+	identity, err := t.verify(token)
+	if err != nil {
+		t.logger.Error("rejected bearer token: ", err.Error())
+		return Identity{}, ErrUnauthenticated
+	}
+	return identity, nil
+}
+
+func (t *Tokens) verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, ErrTokenInvalid
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, errors.Join(ErrTokenInvalid, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, errors.Join(ErrTokenInvalid, err)
+	}
+
+	input := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range t.keys {
+		if (header.Kid != "" && key.id != "" && key.id != header.Kid) || !key.accepts(header.Alg) {
+			continue
+		}
+		if key.verify(header.Alg, input, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Identity{}, fmt.Errorf("signature verification failed, alg '%s', kid '%s'", header.Alg, header.Kid)
+	}
+
+	claims := map[string]any{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, errors.Join(ErrTokenInvalid, err)
+	}
+	return t.checkClaims(claims)
+}
+
+func decodeSegment(segment string, result any) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, result)
+}
+
+func (t *Tokens) checkClaims(claims map[string]any) (Identity, error) {
+	now := time.Now()
+	expires, found := claims["exp"].(float64)
+	if !found {
+		return Identity{}, fmt.Errorf("missing exp claim")
+	} else if now.After(time.Unix(int64(expires), 0).Add(clock_skew)) {
+		return Identity{}, fmt.Errorf("token expired")
+	}
+	if not_before, found := claims["nbf"].(float64); found && now.Add(clock_skew).Before(time.Unix(int64(not_before), 0)) {
+		return Identity{}, fmt.Errorf("token not valid yet")
+	}
+	if t.issuer != "" && claims["iss"] != t.issuer {
+		return Identity{}, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if t.audience != "" && !claimContains(claims["aud"], t.audience) {
+		return Identity{}, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Identity{}, fmt.Errorf("missing sub claim")
+	}
+
+	// a token without a known role is authenticated, but has no permissions
+	identity := Identity{Name: subject}
+	var roles []any
+	switch value := claims[t.role_claim].(type) {
+	case string:
+		roles = []any{value}
+	case []any:
+		roles = value
+	}
+	for _, value := range roles {
+		role, err := ParseRole(fmt.Sprint(value))
+		if err == nil && (identity.Role == "" || Allows(role, identity.Role)) {
+			identity.Role = role
+		}
+	}
+	return identity, nil
+}
+
+// claimContains reports whether the claim is the value or a list containing it.
+func claimContains(claim any, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []any:
+		for _, item := range claim {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+type testKeys struct {
+	hmac       []byte
+	rsa        *rsa.PrivateKey
+	ed25519    ed25519.PrivateKey
+	other_rsa  *rsa.PrivateKey
+	jwks_file  string
+	pem_file   string
+	hmac_file  string
+	public_pem []byte
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	keys := testKeys{hmac: make([]byte, 32)}
+	rand.Read(keys.hmac)
+	var err error
+	if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if keys.other_rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if _, keys.ed25519, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hmac", "alg": alg_hs256, "k": encode(keys.hmac)},
+		{"kty": "RSA", "kid": "rsa", "n": encode(keys.rsa.N.Bytes()), "e": encode(big.NewInt(int64(keys.rsa.E)).Bytes())},
+		{"kty": "OKP", "kid": "ed25519", "crv": "Ed25519", "x": encode(keys.ed25519.Public().(ed25519.PublicKey))},
+	}})
+
+	for _, public := range []any{keys.rsa.Public(), keys.ed25519.Public()} {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys.public_pem = append(keys.public_pem, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+
+	dir := t.TempDir()
+	keys.jwks_file = filepath.Join(dir, "jwks.json")
+	keys.pem_file = filepath.Join(dir, "keys.pem")
+	keys.hmac_file = filepath.Join(dir, "secret")
+	for file, content := range map[string][]byte{keys.jwks_file: jwks, keys.pem_file: keys.public_pem, keys.hmac_file: []byte(encode(keys.hmac))} {
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+func testLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.NewLogger(io.Discard, logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func loadTestTokens(t *testing.T, config TokenConfig) *Tokens {
+	t.Helper()
+	tokens, err := LoadTokens(config, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// signToken creates a token with the header alg and kid signed by key,
+// which is a []byte HMAC secret, *rsa.PrivateKey or ed25519.PrivateKey.
+func signToken(t *testing.T, alg, kid string, claims map[string]any, key any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(input))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:]); err != nil {
+			t.Fatal(err)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix(), "role": "editor"}
+}
+
+func TestVerifyValidTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := loadTestTokens(t, TokenConfig{KeyFile: keys.jwks_file})
+	pem_tokens := loadTestTokens(t, TokenConfig{KeyFile: keys.pem_file})
+	secret := loadTestTokens(t, TokenConfig{KeyFile: keys.hmac_file})
+
+	tests := []struct {
+		name   string
+		tokens *Tokens
+		token  string
+	}{
+		{"jwks HS256", jwks, signToken(t, alg_hs256, "hmac", validClaims(), keys.hmac)},
+		{"jwks RS256", jwks, signToken(t, alg_rs256, "rsa", validClaims(), keys.rsa)},
+		{"jwks EdDSA", jwks, signToken(t, alg_eddsa, "ed25519", validClaims(), keys.ed25519)},
+		{"jwks without kid", jwks, signToken(t, alg_rs256, "", validClaims(), keys.rsa)},
+		{"pem RS256", pem_tokens, signToken(t, alg_rs256, "", validClaims(), keys.rsa)},
+		{"pem EdDSA", pem_tokens, signToken(t, alg_eddsa, "", validClaims(), keys.ed25519)},
+		{"raw secret", secret, signToken(t, alg_hs256, "", validClaims(), []byte(base64.RawURLEncoding.EncodeToString(keys.hmac)))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := test.tokens.Verify(test.token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if identity.Name != "alice" || identity.Role != database.RoleEditor {
+				t.Errorf("identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectedTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := loadTestTokens(t, TokenConfig{KeyFile: keys.jwks_file, Issuer: "https://issuer", Audience: "songs"})
+	pem_tokens := loadTestTokens(t, TokenConfig{KeyFile: keys.pem_file})
+	now := time.Now()
+	claims := func(changes map[string]any) map[string]any {
+		result := map[string]any{"sub": "alice", "exp": now.Add(time.Hour).Unix(), "iss": "https://issuer", "aud": "songs"}
+		for name, value := range changes {
+			if value == nil {
+				delete(result, name)
+			} else {
+				result[name] = value
+			}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		tokens *Tokens
+		token  string
+	}{
+		{"none alg", jwks, signToken(t, "none", "", claims(nil), nil)},
+		{"RSA key used as HMAC secret", pem_tokens, signToken(t, alg_hs256, "", claims(nil), keys.public_pem)},
+		{"RS256 header with Ed25519 signature", jwks, signToken(t, alg_rs256, "ed25519", claims(nil), keys.ed25519)},
+		{"HMAC key with another alg", jwks, signToken(t, alg_rs256, "hmac", claims(nil), keys.rsa)},
+		{"unknown key", jwks, signToken(t, alg_rs256, "rsa", claims(nil), keys.other_rsa)},
+		{"kid of another key", jwks, signToken(t, alg_hs256, "rsa", claims(nil), keys.hmac)},
+		{"expired", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"exp": now.Add(-time.Hour).Unix()}), keys.hmac)},
+		{"missing exp", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"exp": nil}), keys.hmac)},
+		{"not valid yet", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"nbf": now.Add(time.Hour).Unix()}), keys.hmac)},
+		{"wrong issuer", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"iss": "https://other"}), keys.hmac)},
+		{"missing issuer", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"iss": nil}), keys.hmac)},
+		{"wrong audience", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"aud": "other"}), keys.hmac)},
+		{"wrong audience list", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"aud": []string{"a", "b"}}), keys.hmac)},
+		{"missing subject", jwks, signToken(t, alg_hs256, "hmac", claims(map[string]any{"sub": nil}), keys.hmac)},
+		{"malformed", jwks, "a.b.c"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if identity, err := test.tokens.Verify(test.token); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("Verify = %+v, %v, want ErrUnauthenticated", identity, err)
+			}
+		})
+	}
+
+	// the checks allow for clock skew and lists of audiences
+	accepted := []map[string]any{
+		claims(map[string]any{"exp": now.Add(-clock_skew / 2).Unix()}),
+		claims(map[string]any{"nbf": now.Add(clock_skew / 2).Unix()}),
+		claims(map[string]any{"aud": []string{"other", "songs"}}),
+	}
+	for _, accepted_claims := range accepted {
+		if _, err := jwks.Verify(signToken(t, alg_hs256, "hmac", accepted_claims, keys.hmac)); err != nil {
+			t.Errorf("claims %v rejected: %v", accepted_claims, err)
+		}
+	}
+}
+
+func TestRoleMapping(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := loadTestTokens(t, TokenConfig{KeyFile: keys.jwks_file})
+	custom := loadTestTokens(t, TokenConfig{KeyFile: keys.jwks_file, RoleClaim: "permissions"})
+
+	tests := []struct {
+		name   string
+		tokens *Tokens
+		claim  string
+		value  any
+		want   database.Role
+	}{
+		{"single role", tokens, "role", "reader", database.RoleReader},
+		{"highest of a list", tokens, "role", []string{"reader", "admin", "editor"}, database.RoleAdmin},
+		{"unknown roles are ignored", tokens, "role", []string{"owner", "editor"}, database.RoleEditor},
+		{"unknown role", tokens, "role", "owner", ""},
+		{"no role", tokens, "", nil, ""},
+		{"custom claim", custom, "permissions", "admin", database.RoleAdmin},
+		{"default claim ignored with a custom one", custom, "role", "admin", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+			if test.claim != "" {
+				claims[test.claim] = test.value
+			}
+			identity, err := test.tokens.Verify(signToken(t, alg_eddsa, "ed25519", claims, keys.ed25519))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if identity.Role != test.want {
+				t.Errorf("role = %q, want %q", identity.Role, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadTokensRejectsWeakHMACKey(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	jwks := filepath.Join(dir, "jwks.json")
+	os.WriteFile(secret, []byte("short"), 0o600)
+	os.WriteFile(jwks, []byte(`{"kty":"oct","k":"`+base64.RawURLEncoding.EncodeToString([]byte("short"))+`"}`), 0o600)
+	for _, file := range []string{secret, jwks} {
+		if _, err := LoadTokens(TokenConfig{KeyFile: file}, testLogger(t)); !errors.Is(err, ErrWeakHMACKey) {
+			t.Errorf("LoadTokens(%s) error = %v, want ErrWeakHMACKey", filepath.Base(file), err)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/sqlbuilder"
+	"github.com/jackc/pgx/v5"
+)
+
+// AlbumSummary is a release of a group as listed by GetAlbums.
+type AlbumSummary struct {
+	Group       string `json:"group"`
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+	CoverURL    string `json:"cover_url,omitempty"`
+	TrackCount  int    `json:"track_count"`
+}
+
+// Album is an album with its tracks ordered by number.
+type Album struct {
+	AlbumSummary
+	Tracks []Track `json:"tracks"`
+}
+
+// Track is a song of an album. A song with InheritReleaseDate has the release date of the album
+// and follows its changes until the song's own release date is changed.
+type Track struct {
+	Number             int    `json:"number"`
+	Song               string `json:"song"`
+	ReleaseDate        string `json:"release_date,omitempty"`
+	InheritReleaseDate bool   `json:"inherit_release_date"`
+}
+
+type AlbumPage struct {
+	PageIndex uint           `json:"page_idx"`
+	PageCount uint           `json:"page_count"`
+	Entries   []AlbumSummary `json:"entries"`
+}
+
+// ValidateTracks returns ErrInvalidTracks unless every track has a positive number and a song
+// and neither is repeated.
+func ValidateTracks(tracks []Track) error {
+	numbers := make(map[int]struct{}, len(tracks))
+	songs := make(map[string]struct{}, len(tracks))
+	for _, track := range tracks {
+		_, number_taken := numbers[track.Number]
+		_, song_taken := songs[track.Song]
+		if track.Number < 1 || track.Song == "" || number_taken || song_taken {
+			return ErrInvalidTracks
+		}
+		numbers[track.Number] = struct{}{}
+		songs[track.Song] = struct{}{}
+	}
+	return nil
+}
+
+// albumState is an album row, locked for the rest of the transaction if read with lockAlbumQuery.
+type albumState struct {
+	id           int64
+	group        string
+	release_date time.Time
+	cover_url    string
+}
+
+func (db *Db) getAlbumState(ctx context.Context, transaction pgx.Tx, query, group, title string) (albumState, error) {
+	state := albumState{group: group}
+	err := transaction.QueryRow(ctx, query, group, title).Scan(&state.id, &state.release_date, &state.cover_url)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return albumState{}, ErrAlbumNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get album: ", err.Error())
+		return albumState{}, err
+	}
+	return state, nil
+}
+
+// inheritReleaseDate sets the song release date to the album's one and records the change.
+func (db *Db) inheritReleaseDate(ctx context.Context, transaction pgx.Tx, song_id int64, previous Song, date time.Time, author string) error {
+	current := previous
+	current.ReleaseDate = date.Format(DateFmt)
+	if current.ReleaseDate == previous.ReleaseDate {
+		return nil
+	}
+	db.log(ctx).Info("song '", previous.Song, "' inherits album release date ", current.ReleaseDate)
+	if _, err := transaction.Exec(ctx, setReleaseDateQuery, song_id, date); err != nil {
+		db.log(ctx).Error("failed to update song release date: ", err.Error())
+		return err
+	}
+	return db.addRevision(ctx, transaction, song_id, author, &previous, current)
+}
+
+func (db *Db) setTrack(ctx context.Context, transaction pgx.Tx, album albumState, track Track, author string) error {
+	song_id, previous, err := db.getSongState(ctx, transaction, album.group, track.Song)
+	if err != nil {
+		return err
+	}
+	if _, err = transaction.Exec(ctx, detachTrackQuery, album.id, track.Number, song_id); err != nil {
+		db.log(ctx).Error("failed to detach track: ", err.Error())
+		return err
+	}
+	if _, err = transaction.Exec(ctx, addTrackQuery, album.id, song_id, track.Number, track.InheritReleaseDate); err != nil {
+		db.log(ctx).Error("failed to add track: ", err.Error())
+		return err
+	}
+	if track.InheritReleaseDate {
+		return db.inheritReleaseDate(ctx, transaction, song_id, previous, album.release_date, author)
+	}
+	return nil
+}
+
+func (db *Db) AddAlbum(ctx context.Context, group, title string, date time.Time, cover_url string, tracks []Track, author string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of AddAlbum: group and/or title is empty")
+		return ErrInvalidData
+	} else if err = ValidateTracks(tracks); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+
+	db.log(ctx).Info("adding album '", title, "', group '", group, "', ", len(tracks), " tracks")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	group_id, err := db.getOrAddGroupID(ctx, group, transaction)
+	if err != nil {
+		db.log(ctx).Error("failed to get group id: ", err.Error())
+		return err
+	}
+	album := albumState{group: group, release_date: date, cover_url: cover_url}
+	err = transaction.QueryRow(ctx, addAlbumQuery, group_id, title, date, cover_url).Scan(&album.id)
+	if isUniqueViolation(err, uniqueAlbumConstraint) {
+		db.log(ctx).Error(ErrAlbumExists.Error())
+		return ErrAlbumExists
+	} else if err != nil {
+		db.log(ctx).Error("failed to add album: ", err.Error())
+		return err
+	}
+	for _, track := range tracks {
+		if err = db.setTrack(ctx, transaction, album, track, author); err != nil {
+			return err
+		}
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("album successfully added")
+	return nil
+}
+
+func (db *Db) GetAlbum(ctx context.Context, group, title string) (_ Album, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of GetAlbum: group and/or title is empty")
+		return Album{}, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving album '", title, "', group '", group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return Album{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	state, err := db.getAlbumState(ctx, transaction, getAlbumQuery, group, title)
+	if err != nil {
+		return Album{}, err
+	}
+	rows, err := transaction.Query(ctx, getAlbumTracksQuery, state.id)
+	if err != nil {
+		db.log(ctx).Error("failed to get album tracks: ", err.Error())
+		return Album{}, err
+	}
+	defer rows.Close()
+	result := Album{Tracks: make([]Track, 0)}
+	result.Group, result.Title, result.ReleaseDate, result.CoverURL = group, title, state.release_date.Format(DateFmt), state.cover_url
+	for rows.Next() {
+		track := Track{}
+		var date time.Time
+		if err = rows.Scan(&track.Number, &track.Song, &date, &track.InheritReleaseDate); err != nil {
+			db.log(ctx).Error("failed to read album track: ", err.Error())
+			return Album{}, err
+		}
+		track.ReleaseDate = date.Format(DateFmt)
+		result.Tracks = append(result.Tracks, track)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get album tracks: ", err.Error())
+		return Album{}, err
+	}
+	result.TrackCount = len(result.Tracks)
+	return result, nil
+}
+
+// GetAlbums returns albums ordered by group and title.
+func (db *Db) GetAlbums(ctx context.Context, group string, page_idx, page_size uint) (_ AlbumPage, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("retrieving albums, group '", group, "', page ", page_idx, ", page size ", page_size)
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return AlbumPage{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	count, err := db.getCount(ctx, transaction, getAlbumsCountQuery, group)
+	if err != nil {
+		return AlbumPage{}, err
+	}
+	page_count, err := db.validatePageIndex(ctx, count, page_idx, page_size)
+	if err != nil {
+		return AlbumPage{}, err
+	}
+
+	rows, err := transaction.Query(ctx, getAlbumsQuery, group, page_size, page_idx*page_size)
+	if err != nil {
+		db.log(ctx).Error("failed to retrieve albums: ", err.Error())
+		return AlbumPage{}, err
+	}
+	defer rows.Close()
+	result := AlbumPage{PageCount: page_count, PageIndex: page_idx, Entries: make([]AlbumSummary, 0)}
+	for rows.Next() {
+		album := AlbumSummary{}
+		var date time.Time
+		if err = rows.Scan(&album.Group, &album.Title, &date, &album.CoverURL, &album.TrackCount); err != nil {
+			db.log(ctx).Error("failed to read album: ", err.Error())
+			return AlbumPage{}, err
+		}
+		album.ReleaseDate = date.Format(DateFmt)
+		result.Entries = append(result.Entries, album)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to retrieve albums: ", err.Error())
+		return AlbumPage{}, err
+	}
+	return result, nil
+}
+
+// UpdateAlbum changes the given album details. Songs inheriting the release date get the new one.
+func (db *Db) UpdateAlbum(ctx context.Context, group, title, new_title, new_cover_url string, new_release_date *time.Time, author string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of UpdateAlbum: group and/or title is empty")
+		return ErrInvalidData
+	} else if new_title == "" && new_cover_url == "" && new_release_date == nil {
+		// nothing to update
+		db.log(ctx).Debug("empty update: group '", group, "', album '", title, "'")
+		return nil
+	}
+
+	db.log(ctx).Info("updating album '", title, "', group '", group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	album, err := db.getAlbumState(ctx, transaction, lockAlbumQuery, group, title)
+	if err != nil {
+		return err
+	}
+	update := sqlbuilder.Builder{}
+	if new_title != "" {
+		update.Set("title", new_title)
+	}
+	if new_cover_url != "" {
+		update.Set("cover_url", new_cover_url)
+	}
+	if new_release_date != nil {
+		update.Set("release_date", *new_release_date)
+	}
+	update.Where("id = " + update.Param(album.id))
+	query := update.Update(updateAlbumTable)
+	db.log(ctx).Debug("resulting query: ", query)
+
+	_, err = transaction.Exec(ctx, query, update.Args()...)
+	if isUniqueViolation(err, uniqueAlbumConstraint) {
+		db.log(ctx).Error(ErrAlbumExists.Error())
+		return ErrAlbumExists
+	} else if err != nil {
+		db.log(ctx).Error("failed to update album: ", err.Error())
+		return err
+	}
+
+	if new_release_date != nil {
+		if err = db.updateInheritedReleaseDates(ctx, transaction, album.id, *new_release_date, author); err != nil {
+			return err
+		}
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("update successful")
+	return nil
+}
+
+func (db *Db) updateInheritedReleaseDates(ctx context.Context, transaction pgx.Tx, album_id int64, date time.Time, author string) error {
+	type inheritingSong struct {
+		id    int64
+		state Song
+	}
+	rows, err := transaction.Query(ctx, getInheritingQuery, album_id)
+	if err != nil {
+		db.log(ctx).Error("failed to get album tracks: ", err.Error())
+		return err
+	}
+	// the rows have to be read before the songs are updated on the same connection
+	songs := []inheritingSong{}
+	for rows.Next() {
+		song := inheritingSong{}
+		var song_date time.Time
+		if err = rows.Scan(&song.id, &song.state.Group, &song.state.Song, &song.state.Text, &song.state.URL, &song_date); err != nil {
+			rows.Close()
+			db.log(ctx).Error("failed to read album track: ", err.Error())
+			return err
+		}
+		song.state.ReleaseDate = song_date.Format(DateFmt)
+		songs = append(songs, song)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get album tracks: ", err.Error())
+		return err
+	}
+
+	for _, song := range songs {
+		if err = db.inheritReleaseDate(ctx, transaction, song.id, song.state, date, author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Db) DeleteAlbum(ctx context.Context, group, title string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of DeleteAlbum: group and/or title is empty")
+		return ErrInvalidData
+	}
+	db.log(ctx).Info("deleting album '", title, "', group '", group, "'")
+	tag, err := db.pool.Exec(ctx, deleteAlbumQuery, group, title)
+	if err != nil {
+		db.log(ctx).Error("failed to delete album: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return ErrAlbumNotFound
+	}
+	db.log(ctx).Info("deletion successful")
+	return nil
+}
+
+func (db *Db) SetTrack(ctx context.Context, group, title string, track Track, author string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of SetTrack: group and/or title is empty")
+		return ErrInvalidData
+	} else if err = ValidateTracks([]Track{track}); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+
+	db.log(ctx).Info("setting track ", track.Number, " of album '", title, "', group '", group, "' to '", track.Song, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	album, err := db.getAlbumState(ctx, transaction, lockAlbumQuery, group, title)
+	if err != nil {
+		return err
+	}
+	if err = db.setTrack(ctx, transaction, album, track, author); err != nil {
+		return err
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("track set")
+	return nil
+}
+
+// RemoveTrack detaches the track from the album, the song stays in the library.
+func (db *Db) RemoveTrack(ctx context.Context, group, title string, number int) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of RemoveTrack: group and/or title is empty")
+		return ErrInvalidData
+	}
+	db.log(ctx).Info("removing track ", number, " of album '", title, "', group '", group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	album, err := db.getAlbumState(ctx, transaction, lockAlbumQuery, group, title)
+	if err != nil {
+		return err
+	}
+	tag, err := transaction.Exec(ctx, removeTrackQuery, album.id, number)
+	if err != nil {
+		db.log(ctx).Error("failed to remove track: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		db.log(ctx).Error(ErrTrackNotFound.Error())
+		return ErrTrackNotFound
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("track removed")
+	return nil
+}
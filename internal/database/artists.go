@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type ArtistRole string
+
+const (
+	ArtistPrimary  ArtistRole = "primary"
+	ArtistFeatured ArtistRole = "featured"
+	ArtistComposer ArtistRole = "composer"
+	ArtistLyricist ArtistRole = "lyricist"
+)
+
+// artistRoles lists the roles in the order credits are listed
+var artistRoles = []ArtistRole{ArtistPrimary, ArtistFeatured, ArtistComposer, ArtistLyricist}
+
+// Artist is a credit of a song. Artists are stored as groups, the group of a song is always
+// credited as its primary artist.
+type Artist struct {
+	Name string     `json:"name"`
+	Role ArtistRole `json:"role"`
+}
+
+// ValidateArtists returns ErrInvalidArtists unless every artist has a name and a known role
+// and no credit is repeated.
+func ValidateArtists(artists []Artist) error {
+	credits := make(map[Artist]struct{}, len(artists))
+	for _, artist := range artists {
+		_, repeated := credits[artist]
+		if artist.Name == "" || !slices.Contains(artistRoles, artist.Role) || repeated {
+			return ErrInvalidArtists
+		}
+		credits[artist] = struct{}{}
+	}
+	return nil
+}
+
+func compareArtists(a, b Artist) int {
+	if cmp := slices.Index(artistRoles, a.Role) - slices.Index(artistRoles, b.Role); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// creditedArtists returns the credits of a song of the group with the given additional artists,
+// ordered like the credits read from the database.
+func creditedArtists(group string, artists []Artist) []Artist {
+	result := make([]Artist, 0, len(artists)+1)
+	result = append(result, Artist{Name: group, Role: ArtistPrimary})
+	for _, artist := range artists {
+		if !slices.Contains(result, artist) {
+			result = append(result, artist)
+		}
+	}
+	slices.SortFunc(result, compareArtists)
+	return result
+}
+
+// setArtists credits the group as the primary artist of the song together with the artists.
+// Credits the song already has are kept.
+func (db *Db) setArtists(ctx context.Context, transaction pgx.Tx, song_id, group_id int64, artists []Artist) error {
+	if _, err := transaction.Exec(ctx, addArtistQuery, song_id, group_id, string(ArtistPrimary)); err != nil {
+		db.log(ctx).Error("failed to credit the group: ", err.Error())
+		return err
+	}
+	for _, artist := range artists {
+		artist_id, err := db.getOrAddGroupID(ctx, artist.Name, transaction)
+		if err != nil {
+			db.log(ctx).Error("failed to get artist id: ", err.Error())
+			return err
+		}
+		if _, err = transaction.Exec(ctx, addArtistQuery, song_id, artist_id, string(artist.Role)); err != nil {
+			db.log(ctx).Error("failed to credit artist '", artist.Name, "': ", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// movePrimaryArtist replaces the previous group of the song with the new one in its credits.
+func (db *Db) movePrimaryArtist(ctx context.Context, transaction pgx.Tx, song_id int64, previous_group string, group_id int64) error {
+	if _, err := transaction.Exec(ctx, unsetPrimaryQuery, song_id, previous_group); err != nil {
+		db.log(ctx).Error("failed to remove the previous group from credits: ", err.Error())
+		return err
+	}
+	return db.setArtists(ctx, transaction, song_id, group_id, nil)
+}
+
+// replaceArtists sets the credits of the song to its group and the artists.
+func (db *Db) replaceArtists(ctx context.Context, transaction pgx.Tx, song_id, group_id int64, artists []Artist) error {
+	if _, err := transaction.Exec(ctx, clearArtistsQuery, song_id); err != nil {
+		db.log(ctx).Error("failed to clear credits: ", err.Error())
+		return err
+	}
+	return db.setArtists(ctx, transaction, song_id, group_id, artists)
+}
+
+func (db *Db) getArtists(ctx context.Context, transaction pgx.Tx, song_id int64) ([]Artist, error) {
+	rows, err := transaction.Query(ctx, getArtistsQuery, song_id)
+	if err != nil {
+		db.log(ctx).Error("failed to get credits: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	result := []Artist{}
+	for rows.Next() {
+		artist := Artist{}
+		var role string
+		if err = rows.Scan(&artist.Name, &role); err != nil {
+			db.log(ctx).Error("failed to read credit: ", err.Error())
+			return nil, err
+		}
+		artist.Role = ArtistRole(role)
+		result = append(result, artist)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get credits: ", err.Error())
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// libraryCursor is the position of a song in the library order. Clients see it only as an opaque string.
+type libraryCursor struct {
+	Group string `json:"g"`
+	Song  string `json:"s"`
+	// ReleaseDate is in internalDateFmt
+	ReleaseDate string `json:"d"`
+	// Order is the name of the order the cursor was created for
+	Order string `json:"o,omitempty"`
+	// Backward cursors point at the page before the song, forward ones at the page after it
+	Backward bool `json:"b,omitempty"`
+}
+
+func newLibraryCursor(key libraryKey, order []SortField, backward bool) libraryCursor {
+	return libraryCursor{Group: key.group, Song: key.song, ReleaseDate: key.date, Order: orderName(order), Backward: backward}
+}
+
+func (c libraryCursor) key() libraryKey {
+	return libraryKey{group: c.Group, song: c.Song, date: c.ReleaseDate}
+}
+
+func (c libraryCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned in LibraryPage. An empty string is the start of the library.
+// A cursor is only valid for the order it was created for.
+func decodeCursor(cursor string, order []SortField) (libraryCursor, bool, error) {
+	if cursor == "" {
+		return libraryCursor{}, false, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return libraryCursor{}, false, ErrInvalidCursor
+	}
+	result := libraryCursor{}
+	if err = json.Unmarshal(data, &result); err != nil || result.Order != orderName(order) {
+		return libraryCursor{}, false, ErrInvalidCursor
+	}
+	return result, true, nil
+}
+
+// cursorPage sets the cursors of the neighbouring pages. entries is the page in the listing order,
+// has_more reports whether there are entries past the page in the direction of the cursor.
+func cursorPage(entries []LibraryEntry, order []SortField, cursor libraryCursor, has_cursor, has_more bool) LibraryPage {
+	result := LibraryPage{Entries: entries}
+	if len(entries) == 0 {
+		return result
+	}
+	// a page reached by a cursor always has a neighbour on the side the cursor came from
+	has_next, has_prev := has_more, has_cursor
+	if cursor.Backward {
+		has_next, has_prev = has_cursor, has_more
+	}
+	if has_next {
+		result.NextCursor = newLibraryCursor(entryKey(entries[len(entries)-1]), order, false).encode()
+	}
+	if has_prev {
+		result.PrevCursor = newLibraryCursor(entryKey(entries[0]), order, true).encode()
+	}
+	return result
+}
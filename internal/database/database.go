@@ -1,67 +1,240 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/sqlbuilder"
 	"github.com/golang-migrate/migrate"
 	_ "github.com/golang-migrate/migrate/database/postgres"
 	_ "github.com/golang-migrate/migrate/source/file"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
-	addSongQuery         = "add_song"
-	addSongInfoQuery     = "add_song_info"
-	addGroupQuery        = "add_group"
-	getGroupIdQuery      = "get_group"
-	getSongTextQuery     = "get_song_text"
-	deleteSongQuery      = "delete_song"
-	getLibraryQuery      = "get_all"
-	getLibraryCountQuery = "get_all_count"
-	getSongIdQuery       = "get_song_id"
+	addSongQuery          = "add_song"
+	addSongInfoQuery      = "add_song_info"
+	addGroupQuery         = "add_group"
+	getGroupIdQuery       = "get_group"
+	getSongTextQuery      = "get_song_text"
+	getSongQuery          = "get_song"
+	deleteSongQuery       = "delete_song"
+	getLibraryQuery       = "get_all"
+	getLibraryCountQuery  = "get_all_count"
+	getSongIdQuery        = "get_song_id"
+	searchQuery           = "search"
+	searchCountQuery      = "search_count"
+	addJobQuery           = "add_job"
+	updateJobQuery        = "update_job"
+	getJobQuery           = "get_job"
+	getPendingJobsQuery   = "get_pending_jobs"
+	getSongStateQuery     = "get_song_state"
+	addRevisionQuery      = "add_revision"
+	getRevisionsQuery     = "get_revisions"
+	getRevisionQuery      = "get_revision"
+	getLastRevisionQuery  = "get_last_revision"
+	restoreSongQuery      = "restore_song"
+	restoreSongInfoQuery  = "restore_song_info"
+	getTrashQuery         = "get_trash"
+	getTrashCountQuery    = "get_trash_count"
+	restoreFromTrashQuery = "restore_from_trash"
+	purgeTrashQuery       = "purge_trash"
+	addKeyQuery           = "add_key"
+	getKeyByHashQuery     = "get_key_by_hash"
+	getKeysQuery          = "get_keys"
+	revokeKeyQuery        = "revoke_key"
+	addAlbumQuery         = "add_album"
+	getAlbumQuery         = "get_album"
+	lockAlbumQuery        = "lock_album"
+	getAlbumsQuery        = "get_albums"
+	getAlbumsCountQuery   = "get_albums_count"
+	getAlbumTracksQuery   = "get_album_tracks"
+	deleteAlbumQuery      = "delete_album"
+	detachTrackQuery      = "detach_track"
+	addTrackQuery         = "add_track"
+	removeTrackQuery      = "remove_track"
+	getInheritingQuery    = "get_inheriting_songs"
+	setReleaseDateQuery   = "set_release_date"
+	stopInheritingQuery   = "stop_inheriting"
+	leaveAlbumQuery       = "leave_album"
+	addArtistQuery        = "add_artist"
+	unsetPrimaryQuery     = "unset_primary_artist"
+	clearArtistsQuery     = "clear_artists"
+	getArtistsQuery       = "get_artists"
+
+	uniqueViolationCode   = "23505"
+	uniqueSongConstraint  = "fk_unique_song"
+	uniqueAlbumConstraint = "unique_album"
 
 	getLibraryFilterBase = "SELECT name, song_name, release_date FROM groups JOIN songs" +
-		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE"
+		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE deleted_at IS NULL"
 	getLibraryFilterCountBase = "SELECT COUNT(*) FROM groups JOIN songs" +
-		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE"
-	getLibraryFilterGroupFmt       = " name LIKE $%d"
-	getLibraryFilterSongFmt        = " song_name LIKE $%d"
-	getLibraryFilterReleaseDateFmt = " release_date = $%d"
-	getLibraryFilterCountEnd       = ";"
-	getLibraryFilterPaginationFmt  = " ORDER BY name, song_name, release_date LIMIT $%d OFFSET $%d;"
-
-	updateSongBase           = "UPDATE songs SET"
-	updateSongGroupFmt       = " group_id = $%d"
-	updateSongNameFmt        = " song_name = $%d"
-	updateSongReleaseDateFmt = " release_date = $%d"
-	updateSongEndFmt         = " WHERE id = $%d;"
-
-	updateSongInfoBase           = "UPDATE song_info SET"
-	updateSongInfoTextFmt        = " lyrics = $%d"
-	updateSongInfoUrlFmt         = " url = $%d"
-	updateSongInfoReleaseDateFmt = " release_date = $%d"
-	updateSongInfoEndFmt         = " WHERE song_id = $%d;"
+		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE deleted_at IS NULL"
+	// the keyset queries are the same as the offset ones, named by a comment to tell them apart in metrics
+	getLibraryCursorBase      = "/* get_filtered_cursor */ " + getLibraryFilterBase
+	getLibraryCursorCountBase = "/* get_filtered_cursor_count */ " + getLibraryFilterCountBase
+
+	exportBase = "DECLARE export_cursor NO SCROLL CURSOR FOR SELECT name, song_name, lyrics, url, release_date" +
+		" FROM groups JOIN songs ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id" +
+		" WHERE deleted_at IS NULL"
+	exportFetch       = "FETCH FORWARD 500 FROM export_cursor;"
+	exportCursorClose = "CLOSE export_cursor;"
+
+	migrationVersionQuery = "SELECT version, dirty FROM schema_migrations LIMIT 1;"
+
+	updateSongTable     = "songs"
+	updateSongInfoTable = "song_info"
+	updateAlbumTable    = "albums"
+
+	revisionColumns = "revision, author, created_at, prev_group, prev_name, prev_lyrics, prev_url, prev_release_date," +
+		" group_name, song_name, lyrics, url, release_date"
 
 	internalDateFmt = "2006-01-02"
 	DateFmt         = "02.01.2006"
 )
 
 var (
-	ErrGroupNotFound   = fmt.Errorf("group not found")
-	ErrSongNotFound    = fmt.Errorf("song not found")
-	ErrEmptyFilter     = fmt.Errorf("empty filter")
-	ErrInvalidData     = fmt.Errorf("invalid data")
-	ErrPageOutOfBounds = fmt.Errorf("page out of bounds")
-	ErrNoOutput        = fmt.Errorf("expected one row of output")
+	ErrGroupNotFound    = fmt.Errorf("group not found")
+	ErrSongNotFound     = fmt.Errorf("song not found")
+	ErrEmptyFilter      = fmt.Errorf("empty filter")
+	ErrInvalidData      = fmt.Errorf("invalid data")
+	ErrPageOutOfBounds  = fmt.Errorf("page out of bounds")
+	ErrNoOutput         = fmt.Errorf("expected one row of output")
+	ErrSongExists       = fmt.Errorf("song already exists")
+	ErrJobNotFound      = fmt.Errorf("job not found")
+	ErrRevisionNotFound = fmt.Errorf("revision not found")
+	ErrKeyNotFound      = fmt.Errorf("API key not found")
+	ErrNotMigrated      = fmt.Errorf("database schema is not up to date")
+	ErrInvalidCursor    = fmt.Errorf("invalid cursor")
+	ErrAlbumNotFound    = fmt.Errorf("album not found")
+	ErrAlbumExists      = fmt.Errorf("album already exists")
+	ErrTrackNotFound    = fmt.Errorf("track not found")
+	// ErrInvalidTracks is returned if track numbers aren't positive or a number or a song is repeated
+	ErrInvalidTracks = fmt.Errorf("invalid track list")
+	// ErrInvalidArtists is returned if an artist has no name or an unknown role or a credit is repeated
+	ErrInvalidArtists = fmt.Errorf("invalid artist list")
+	// ErrTimeout is returned if an operation didn't finish within the query timeout
+	ErrTimeout = fmt.Errorf("database operation timed out")
+	// ErrCanceled is returned if an operation was interrupted because its context was canceled
+	ErrCanceled = fmt.Errorf("database operation canceled")
 )
 
+var preparedQueries = []struct {
+	name  string
+	query string
+}{
+	{addGroupQuery, "INSERT INTO groups(name) VALUES ($1) RETURNING id;"},
+	{getGroupIdQuery, "SELECT id FROM groups WHERE name = $1 LIMIT 1;"},
+	{addSongQuery, "INSERT INTO songs(group_id, song_name) VALUES($1, $2) RETURNING id;"},
+	{addSongInfoQuery, "INSERT INTO song_info(song_id, lyrics, url, release_date) VALUES($1, $2, $3, $4);"},
+	{getSongTextQuery, "SELECT lyrics FROM song_info WHERE song_id =" +
+		" (SELECT id FROM songs WHERE group_id = $1 AND song_name = $2 AND deleted_at IS NULL);"},
+	{getSongQuery, "SELECT song_id, lyrics, url, release_date FROM song_info WHERE song_id =" +
+		" (SELECT id FROM songs WHERE group_id = $1 AND song_name = $2 AND deleted_at IS NULL);"},
+	{deleteSongQuery, "UPDATE songs SET deleted_at = now() WHERE group_id = $1 AND song_name = $2 AND deleted_at IS NULL;"},
+	{getLibraryQuery, "SELECT name, song_name, release_date FROM groups JOIN songs" +
+		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE deleted_at IS NULL" +
+		" ORDER BY name, song_name, release_date LIMIT $1 OFFSET $2;"},
+	{getLibraryCountQuery, "SELECT COUNT(*) FROM groups JOIN songs" +
+		" ON groups.id = songs.group_id WHERE deleted_at IS NULL;"},
+	{getSongIdQuery, "SELECT id FROM songs WHERE song_name = $1" +
+		" AND group_id = (SELECT id FROM groups WHERE name = $2) AND deleted_at IS NULL;"},
+	{searchQuery, "SELECT name, song_name, release_date, ts_rank(lyrics_tsv, query) AS rank," +
+		" ts_headline('simple', lyrics, query, 'StartSel=<b>, StopSel=</b>, MaxFragments=3, FragmentDelimiter=\" ... \"')" +
+		" FROM groups JOIN songs ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id," +
+		" websearch_to_tsquery('simple', $1) query WHERE lyrics_tsv @@ query AND deleted_at IS NULL" +
+		" ORDER BY rank DESC, name, song_name LIMIT $2 OFFSET $3;"},
+	{searchCountQuery, "SELECT COUNT(*) FROM songs JOIN song_info ON songs.id = song_info.song_id" +
+		" WHERE lyrics_tsv @@ websearch_to_tsquery('simple', $1) AND deleted_at IS NULL;"},
+	{addJobQuery, "INSERT INTO ingestion_jobs(id, group_name, song_name, status, created_at, updated_at, artists)" +
+		" VALUES($1, $2, $3, $4, $5, $5, $6);"},
+	{updateJobQuery, "UPDATE ingestion_jobs SET status = $2, error = $3, updated_at = now() WHERE id = $1;"},
+	{getJobQuery, "SELECT id, group_name, song_name, status, error, created_at, updated_at, artists" +
+		" FROM ingestion_jobs WHERE id = $1;"},
+	{getPendingJobsQuery, "SELECT id, group_name, song_name, status, error, created_at, updated_at, artists" +
+		" FROM ingestion_jobs WHERE status IN ('queued', 'running') ORDER BY created_at;"},
+	{getSongStateQuery, "SELECT songs.id, name, song_name, lyrics, url, release_date FROM groups JOIN songs" +
+		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id" +
+		" WHERE name = $1 AND song_name = $2 AND deleted_at IS NULL FOR UPDATE OF songs, song_info;"},
+	{addRevisionQuery, "INSERT INTO song_revisions(song_id, revision, author," +
+		" prev_group, prev_name, prev_lyrics, prev_url, prev_release_date, group_name, song_name, lyrics, url, release_date)" +
+		" SELECT $1, COALESCE(MAX(revision), 0) + 1, $2::text, $3::text, $4::text, $5::text, $6::text, $7::date," +
+		" $8::text, $9::text, $10::text, $11::text, $12::date FROM song_revisions WHERE song_id = $1;"},
+	{getRevisionsQuery, "SELECT " + revisionColumns + " FROM song_revisions WHERE song_id = $1 ORDER BY revision;"},
+	{getRevisionQuery, "SELECT " + revisionColumns + " FROM song_revisions WHERE song_id = $1 AND revision = $2;"},
+	{getLastRevisionQuery, "SELECT " + revisionColumns + " FROM song_revisions WHERE song_id = $1" +
+		" ORDER BY revision DESC LIMIT 1;"},
+	{restoreSongQuery, "UPDATE songs SET group_id = $2, song_name = $3 WHERE id = $1;"},
+	{restoreSongInfoQuery, "UPDATE song_info SET lyrics = $2, url = $3, release_date = $4 WHERE song_id = $1;"},
+	{getTrashQuery, "SELECT name, song_name, release_date, deleted_at FROM groups JOIN songs" +
+		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id WHERE deleted_at IS NOT NULL" +
+		" ORDER BY deleted_at DESC, name, song_name LIMIT $1 OFFSET $2;"},
+	{getTrashCountQuery, "SELECT COUNT(*) FROM songs WHERE deleted_at IS NOT NULL;"},
+	{restoreFromTrashQuery, "UPDATE songs SET deleted_at = NULL WHERE id = (SELECT id FROM songs" +
+		" WHERE group_id = (SELECT id FROM groups WHERE name = $1) AND song_name = $2 AND deleted_at IS NOT NULL" +
+		" ORDER BY deleted_at DESC LIMIT 1);"},
+	{purgeTrashQuery, "DELETE FROM songs WHERE deleted_at < $1;"},
+	{addKeyQuery, "INSERT INTO api_keys(id, name, role, key_hash, created_at) VALUES($1, $2, $3, $4, $5);"},
+	{getKeyByHashQuery, "SELECT id, name, role, created_at, revoked_at FROM api_keys" +
+		" WHERE key_hash = $1 AND revoked_at IS NULL;"},
+	{getKeysQuery, "SELECT id, name, role, created_at, revoked_at FROM api_keys ORDER BY created_at;"},
+	{revokeKeyQuery, "UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL;"},
+	{addAlbumQuery, "INSERT INTO albums(group_id, title, release_date, cover_url) VALUES($1, $2, $3, $4) RETURNING id;"},
+	{getAlbumQuery, "SELECT albums.id, release_date, cover_url FROM groups JOIN albums ON groups.id = albums.group_id" +
+		" WHERE name = $1 AND title = $2;"},
+	{lockAlbumQuery, "SELECT albums.id, release_date, cover_url FROM groups JOIN albums ON groups.id = albums.group_id" +
+		" WHERE name = $1 AND title = $2 FOR UPDATE OF albums;"},
+	{getAlbumsQuery, "SELECT name, title, release_date, cover_url, (SELECT COUNT(*) FROM album_tracks JOIN songs" +
+		" ON songs.id = album_tracks.song_id WHERE album_id = albums.id AND deleted_at IS NULL)" +
+		" FROM groups JOIN albums ON groups.id = albums.group_id WHERE $1 = '' OR name = $1" +
+		" ORDER BY name, title LIMIT $2 OFFSET $3;"},
+	{getAlbumsCountQuery, "SELECT COUNT(*) FROM groups JOIN albums ON groups.id = albums.group_id WHERE $1 = '' OR name = $1;"},
+	{getAlbumTracksQuery, "SELECT track_number, song_name, release_date, inherit_release_date FROM album_tracks" +
+		" JOIN songs ON songs.id = album_tracks.song_id JOIN song_info ON songs.id = song_info.song_id" +
+		" WHERE album_id = $1 AND deleted_at IS NULL ORDER BY track_number;"},
+	{deleteAlbumQuery, "DELETE FROM albums USING groups WHERE groups.id = albums.group_id AND name = $1 AND title = $2;"},
+	{detachTrackQuery, "DELETE FROM album_tracks WHERE (album_id = $1 AND track_number = $2) OR song_id = $3;"},
+	{addTrackQuery, "INSERT INTO album_tracks(album_id, song_id, track_number, inherit_release_date) VALUES($1, $2, $3, $4);"},
+	{removeTrackQuery, "DELETE FROM album_tracks WHERE album_id = $1 AND track_number = $2;"},
+	{getInheritingQuery, "SELECT songs.id, name, song_name, lyrics, url, release_date FROM album_tracks" +
+		" JOIN songs ON songs.id = album_tracks.song_id JOIN groups ON groups.id = songs.group_id" +
+		" JOIN song_info ON songs.id = song_info.song_id" +
+		" WHERE album_id = $1 AND inherit_release_date AND deleted_at IS NULL FOR UPDATE OF songs, song_info;"},
+	{setReleaseDateQuery, "UPDATE song_info SET release_date = $2 WHERE song_id = $1;"},
+	{stopInheritingQuery, "UPDATE album_tracks SET inherit_release_date = false WHERE song_id = $1;"},
+	{leaveAlbumQuery, "DELETE FROM album_tracks WHERE song_id = $1;"},
+	{addArtistQuery, "INSERT INTO song_artists(song_id, group_id, role) VALUES($1, $2, $3) ON CONFLICT DO NOTHING;"},
+	{unsetPrimaryQuery, "DELETE FROM song_artists WHERE song_id = $1 AND role = 'primary'" +
+		" AND group_id = (SELECT id FROM groups WHERE name = $2);"},
+	{clearArtistsQuery, "DELETE FROM song_artists WHERE song_id = $1;"},
+	{getArtistsQuery, "SELECT name, role FROM song_artists JOIN groups ON groups.id = song_artists.group_id" +
+		" WHERE song_id = $1 ORDER BY array_position(ARRAY['primary', 'featured', 'composer', 'lyricist'], role), name;"},
+}
+
+// PoolConfig holds connection pool settings. Zero values keep pgxpool defaults.
+type PoolConfig struct {
+	MinConns          int32
+	MaxConns          int32
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// QueryTimeout limits every database operation, 0 means no limit except the caller's context
+	QueryTimeout time.Duration
+}
+
 type Db struct {
-	connection *pgx.Conn
-	logger     *logger.Logger
+	pool   *pgxpool.Pool
+	logger *logger.Logger
+	// the schema version after the migrations were applied on startup
+	migration_version uint
+	query_timeout     time.Duration
 }
 type LibraryEntry struct {
 	Group       string `json:"group"`
@@ -69,21 +242,43 @@ type LibraryEntry struct {
 	ReleaseDate string `json:"release_date"`
 }
 
-type LibraryPage struct {
+type Song struct {
+	Group       string `json:"group" xml:"group"`
+	Song        string `json:"song" xml:"song"`
+	Text        string `json:"text" xml:"text"`
+	URL         string `json:"url" xml:"url"`
+	ReleaseDate string `json:"release_date" xml:"release_date"`
+	// Artists are the credits of the song, the group is always its primary artist.
+	// Revisions and exports don't include them.
+	Artists []Artist `json:"artists,omitempty" xml:"-"`
+}
+
+type SearchResult struct {
+	LibraryEntry
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+type SearchPage struct {
 	PageIndex uint           `json:"page_idx"`
 	PageCount uint           `json:"page_count"`
-	Entries   []LibraryEntry `json:"entries"`
+	Entries   []SearchResult `json:"entries"`
 }
 
-func Init(user, password, host string, port uint16, db_name, migrations_path string, logger *logger.Logger) *Db {
-	cfg := pgx.ConnConfig{
-		User:     user,
-		Password: password,
-		Host:     host,
-		Port:     port,
-		Database: db_name,
-	}
+// LibraryPage is a page of the library. Pages by index have PageIndex and PageCount,
+// pages by cursor have the cursors of the neighbouring pages and, if requested, Count.
+type LibraryPage struct {
+	PageIndex *uint          `json:"page_idx,omitempty"`
+	PageCount *uint          `json:"page_count,omitempty"`
+	Entries   []LibraryEntry `json:"entries"`
+	// NextCursor and PrevCursor are empty if there is no next or previous page
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Count is the number of matching songs
+	Count *int64 `json:"count,omitempty"`
+}
 
+func Init(user, password, host string, port uint16, db_name, migrations_path string, pool_config PoolConfig, logger *logger.Logger) *Db {
 	migrations_path, err := filepath.Abs(migrations_path)
 	if err != nil {
 		logger.Error("failed to get an absolute path to migrations: ", err.Error())
@@ -109,6 +304,11 @@ func Init(user, password, host string, port uint16, db_name, migrations_path str
 		logger.Error("failed to migrate the database: ", err.Error())
 		return nil
 	}
+	migration_version, _, err := migration.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		logger.Error("failed to get the database schema version: ", err.Error())
+		return nil
+	}
 	src_err, db_err := migration.Close()
 	if src_err != nil {
 		logger.Error("failed to migrate the database: ", src_err.Error())
@@ -118,572 +318,923 @@ func Init(user, password, host string, port uint16, db_name, migrations_path str
 	}
 	logger.Info("updating database structure: done")
 
-	connection, err := pgx.Connect(cfg)
+	cfg, err := pgxpool.ParseConfig(db_url.String())
 	if err != nil {
+		logger.Error("failed to parse connection config: ", err.Error())
 		return nil
 	}
-	logger.Info("connected to the database")
-
-	logger.Debug("preparing queries")
-	_, err = connection.Prepare(addGroupQuery, "INSERT INTO groups(name) VALUES ($1) RETURNING id;")
-	if err != nil {
-		logger.Error("failed to prepare ", addGroupQuery, " query: ", err.Error())
-		return nil
+	if pool_config.MinConns > 0 {
+		cfg.MinConns = pool_config.MinConns
 	}
-	_, err = connection.Prepare(getGroupIdQuery, "SELECT id FROM groups WHERE name = $1 LIMIT 1;")
-	if err != nil {
-		logger.Error("failed to prepare ", getGroupIdQuery, " query: ", err.Error())
-		return nil
+	if pool_config.MaxConns > 0 {
+		cfg.MaxConns = pool_config.MaxConns
 	}
-	_, err = connection.Prepare(addSongQuery, "INSERT INTO songs(group_id, song_name) VALUES($1, $2) RETURNING id;")
-	if err != nil {
-		logger.Error("failed to prepare ", addSongQuery, " query: ", err.Error())
-		return nil
+	if pool_config.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = pool_config.MaxConnIdleTime
 	}
-	_, err = connection.Prepare(addSongInfoQuery, "INSERT INTO song_info(song_id, lyrics, url, release_date) VALUES($1, $2, $3, $4);")
-	if err != nil {
-		logger.Error("failed to prepare ", addSongInfoQuery, " query: ", err.Error())
-		return nil
+	if pool_config.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = pool_config.HealthCheckPeriod
 	}
-	_, err = connection.Prepare(getSongTextQuery, "SELECT lyrics FROM song_info WHERE song_id ="+
-		" (SELECT id FROM songs WHERE group_id = $1 AND song_name = $2);")
-	if err != nil {
-		logger.Error("failed to prepare ", getSongTextQuery, " query: ", err.Error())
+	if cfg.MinConns > cfg.MaxConns {
+		logger.Error("minimum connection count ", cfg.MinConns, " exceeds maximum ", cfg.MaxConns)
 		return nil
 	}
-	_, err = connection.Prepare(deleteSongQuery, "DELETE FROM songs WHERE group_id = $1 AND song_name = $2;")
-	if err != nil {
-		logger.Error("failed to prepare ", deleteSongQuery, " query: ", err.Error())
+	tracer := newQueryTracer()
+	cfg.ConnConfig.Tracer = tracer
+	cfg.BeforeClose = tracer.connectionClosed
+	// prepared statements are per connection, so every new pooled connection has to prepare them
+	cfg.AfterConnect = func(ctx context.Context, connection *pgx.Conn) error {
+		logger.Debug("preparing queries")
+		for _, prepared := range preparedQueries {
+			if _, err := connection.Prepare(ctx, prepared.name, prepared.query); err != nil {
+				logger.Error("failed to prepare ", prepared.name, " query: ", err.Error())
+				return err
+			}
+		}
+		logger.Debug("preparing queries: done")
 		return nil
 	}
-	_, err = connection.Prepare(getLibraryQuery, "SELECT name, song_name, release_date FROM groups JOIN songs"+
-		" ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id ORDER BY name, song_name, release_date LIMIT $1 OFFSET $2;")
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
 	if err != nil {
-		logger.Error("failed to prepare ", getLibraryQuery, " query: ", err.Error())
+		logger.Error("failed to create connection pool: ", err.Error())
 		return nil
 	}
-	_, err = connection.Prepare(getLibraryCountQuery, "SELECT COUNT(*) FROM groups JOIN songs"+
-		" ON groups.id = songs.group_id;")
-	if err != nil {
-		logger.Error("failed to prepare ", getLibraryCountQuery, " query: ", err.Error())
+	if err = pool.Ping(context.Background()); err != nil {
+		logger.Error("failed to connect to the database: ", err.Error())
+		pool.Close()
 		return nil
 	}
-	_, err = connection.Prepare(getSongIdQuery, "SELECT id FROM songs WHERE song_name = $1"+
-		" AND group_id = (SELECT id FROM groups WHERE name = $2);")
-	if err != nil {
-		logger.Error("failed to prepare ", getSongIdQuery, " query: ", err.Error())
-		return nil
+	logger.Info("connected to the database, pool size: min ", cfg.MinConns, ", max ", cfg.MaxConns)
+
+	return &Db{pool: pool, logger: logger, migration_version: migration_version, query_timeout: pool_config.QueryTimeout}
+}
+
+// isUniqueViolation reports whether err was caused by the unique constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pg_err *pgconn.PgError
+	return errors.As(err, &pg_err) && pg_err.Code == uniqueViolationCode &&
+		pg_err.ConstraintName == constraint
+}
+
+// isSongExistsError reports whether err was caused by the unique (group, song) constraint.
+func isSongExistsError(err error) bool {
+	return isUniqueViolation(err, uniqueSongConstraint)
+}
+
+// log returns the request-scoped logger from the context or the default one.
+func (db *Db) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, db.logger)
+}
+
+// withTimeout limits an operation to the configured query timeout, if there is one.
+func (db *Db) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.query_timeout == 0 {
+		return context.WithCancel(ctx)
 	}
-	logger.Debug("preparing queries: done")
+	return context.WithTimeout(ctx, db.query_timeout)
+}
 
-	return &Db{connection: connection, logger: logger}
+// contextError replaces the error of an operation interrupted by its context with ErrTimeout
+// or ErrCanceled, so that callers don't have to inspect driver errors.
+func contextError(ctx context.Context, err *error) {
+	if *err == nil {
+		return
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		*err = ErrTimeout
+	case context.Canceled:
+		*err = ErrCanceled
+	}
 }
 
-func (db *Db) getGroupID(name string, transaction *pgx.Tx) (int64, error) {
-	db.logger.Info("trying to retrieve group id, name: '", name, "'")
-	rows, err := transaction.Query(getGroupIdQuery, name)
-	defer rows.Close()
+// begin acquires a connection from the pool and starts a transaction on it.
+// The connection is released back to the pool once the transaction is committed or rolled back.
+func (db *Db) begin(ctx context.Context) (pgx.Tx, error) {
+	return db.pool.Begin(ctx)
+}
+
+func (db *Db) getGroupID(ctx context.Context, name string, transaction pgx.Tx) (int64, error) {
+	db.log(ctx).Info("trying to retrieve group id, name: '", name, "'")
+	rows, err := transaction.Query(ctx, getGroupIdQuery, name)
 	if err != nil {
-		db.logger.Error("failed to get group id: ", err.Error())
+		db.log(ctx).Error("failed to get group id: ", err.Error())
+		return -1, err
 	}
+	defer rows.Close()
 	var group_id int64 = -1
 
 	if rows.Next() {
 		err = rows.Scan(&group_id)
 		if err != nil {
-			db.logger.Error("failed to read group id from query result: ", err.Error())
+			db.log(ctx).Error("failed to read group id from query result: ", err.Error())
 			return -1, err
 		}
 		return group_id, nil
 	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get group id: ", err.Error())
+		return -1, err
+	}
 	return -1, nil
 }
 
-func (db *Db) getOrAddGroupID(name string, transaction *pgx.Tx) (int64, error) {
-	group_id, err := db.getGroupID(name, transaction)
+func (db *Db) getOrAddGroupID(ctx context.Context, name string, transaction pgx.Tx) (int64, error) {
+	group_id, err := db.getGroupID(ctx, name, transaction)
 	if err != nil {
 		return -1, err
 	} else if group_id == -1 {
-		db.logger.Info("group '", name, "' not found, adding it")
-		rows, err := transaction.Query(addGroupQuery, name)
-		defer rows.Close()
-		if err != nil {
-			db.logger.Error("failed to add new group: ", err.Error())
-			return -1, err
-		}
-		if !rows.Next() {
-			db.logger.Error(ErrNoOutput.Error())
+		db.log(ctx).Info("group '", name, "' not found, adding it")
+		err = transaction.QueryRow(ctx, addGroupQuery, name).Scan(&group_id)
+		if err == pgx.ErrNoRows {
+			db.log(ctx).Error(ErrNoOutput.Error())
 			return -1, ErrNoOutput
-		}
-
-		err = rows.Scan(&group_id)
-		if err != nil {
-			db.logger.Error("failed to read group id from query result: ", err.Error())
+		} else if err != nil {
+			db.log(ctx).Error("failed to add new group: ", err.Error())
 			return -1, err
 		}
 	}
 	return group_id, nil
 }
 
-func (db *Db) validatePageIndex(query_result *pgx.Rows, page_idx, page_size uint) (uint, error) {
-	if !query_result.Next() {
-		db.logger.Error(ErrNoOutput.Error())
-		return 0, ErrNoOutput
+func (db *Db) validatePageIndex(ctx context.Context, count int64, page_idx, page_size uint) (uint, error) {
+	page_count, err := countPages(count, page_idx, page_size)
+	if err == ErrInvalidData {
+		db.log(ctx).Error("page size must be non-zero")
+		return 0, err
+	} else if err != nil {
+		db.log(ctx).Error("page ", page_idx, " out of bounds, max page: ", page_count)
+		return 0, err
 	}
+	return page_count, nil
+}
+
+func (db *Db) getCount(ctx context.Context, transaction pgx.Tx, query string, args ...any) (int64, error) {
 	var count int64
-	if err := query_result.Scan(&count); err != nil {
-		db.logger.Error("failed to retrieve count: ", err.Error())
+	err := transaction.QueryRow(ctx, query, args...).Scan(&count)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrNoOutput.Error())
+		return 0, ErrNoOutput
+	} else if err != nil {
+		db.log(ctx).Error("failed to get library entries count: ", err.Error())
 		return 0, err
 	}
-	page_count := count / int64(page_size)
-	if count%int64(page_size) != 0 {
-		page_count++
+	return count, nil
+}
+
+func (db *Db) readLibraryEntries(ctx context.Context, rows pgx.Rows, result *LibraryPage) error {
+	defer rows.Close()
+	buffer := LibraryEntry{}
+	time_buffer := time.Time{}
+	for rows.Next() {
+		err := rows.Scan(&buffer.Group, &buffer.Song, &time_buffer)
+		if err != nil {
+			db.log(ctx).Error("failed to retrieve library entry: ", err.Error(), ", retrieved: ", len(result.Entries))
+			return err
+		}
+		buffer.ReleaseDate = time_buffer.Format(DateFmt)
+		db.log(ctx).Debug("adding entry: group '", buffer.Group, "', song '", buffer.Song, "'")
+		result.Entries = append(result.Entries, buffer)
 	}
-	if page_idx != 0 && int64(page_idx) >= page_count {
-		db.logger.Error("page ", page_idx, " out of bounds, max page: ", page_count)
-		return 0, ErrPageOutOfBounds
+	if err := rows.Err(); err != nil {
+		db.log(ctx).Error("failed to retrieve library: ", err.Error())
+		return err
 	}
-	return uint(page_count), nil
+	return nil
 }
 
-func (db *Db) getAll(page_idx, page_size uint) (LibraryPage, error) {
-	//TODO: page count
-	db.logger.Info("retrieving library data, page ", page_idx, ", page size ", page_size)
+func (db *Db) getAll(ctx context.Context, page_idx, page_size uint) (LibraryPage, error) {
+	db.log(ctx).Info("retrieving library data, page ", page_idx, ", page size ", page_size)
 
-	transaction, err := db.connection.Begin()
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err.Error())
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
 		return LibraryPage{}, err
 	}
-	defer transaction.Rollback()
+	defer transaction.Rollback(context.Background())
 
 	// validate page index
-	count_rows, err := transaction.Query(getLibraryCountQuery)
-	defer count_rows.Close()
+	count, err := db.getCount(ctx, transaction, getLibraryCountQuery)
 	if err != nil {
-		db.logger.Error("failed to get library entries count: ", err.Error())
 		return LibraryPage{}, err
 	}
-	page_count, err := db.validatePageIndex(count_rows, page_idx, page_size)
+	page_count, err := db.validatePageIndex(ctx, count, page_idx, page_size)
 	if err != nil {
 		return LibraryPage{}, err
 	}
-	count_rows.Close()
 
 	// get the result
-	rows, err := transaction.Query(getLibraryQuery, page_size, page_idx*page_size)
-	defer rows.Close()
+	rows, err := transaction.Query(ctx, getLibraryQuery, page_size, page_idx*page_size)
 	if err != nil {
-		db.logger.Error("failed to retrieve library: ", err.Error())
+		db.log(ctx).Error("failed to retrieve library: ", err.Error())
 		return LibraryPage{}, err
 	}
-	result := LibraryPage{PageCount: page_count, PageIndex: page_idx}
-	buffer := LibraryEntry{}
-	time_buffer := time.Time{}
-	for rows.Next() {
-		err = rows.Scan(&buffer.Group, &buffer.Song, &time_buffer)
-		if err != nil {
-			db.logger.Error("failed to retrieve library entry: ", err.Error(), ", retrieved: ", len(result.Entries))
-			return LibraryPage{}, err
-		}
-		buffer.ReleaseDate = time_buffer.Format(DateFmt)
-		db.logger.Debug("adding entry: group '", buffer.Group, "', song '", buffer.Song, "'")
-		result.Entries = append(result.Entries, buffer)
+	result := LibraryPage{PageCount: &page_count, PageIndex: &page_idx}
+	if err = db.readLibraryEntries(ctx, rows, &result); err != nil {
+		return LibraryPage{}, err
 	}
-	rows.Close()
 
-	err = transaction.Commit()
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
 		return LibraryPage{}, err
 	}
 	return result, nil
 }
 
-func (db *Db) AddSong(group string, name string, text string, url string, date time.Time) error {
+func (db *Db) AddSong(ctx context.Context, group string, name string, text string, url string, date time.Time, artists []Artist, author string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
 	if group == "" || name == "" || text == "" || url == "" {
-		db.logger.Error("invalid use of AddSong: one of the parameters is empty")
+		db.log(ctx).Error("invalid use of AddSong: one of the parameters is empty")
 		return ErrInvalidData
+	} else if err = ValidateArtists(artists); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
 	}
 
-	db.logger.Info("adding song, group name: '", group, "' song name: '", name, "'")
-	transaction, err := db.connection.Begin()
+	db.log(ctx).Info("adding song, group name: '", group, "' song name: '", name, "'")
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err.Error())
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	if err = db.addSong(ctx, transaction, group, name, text, url, date, artists, author); err != nil {
 		return err
 	}
-	defer transaction.Rollback()
 
-	group_id, err := db.getOrAddGroupID(group, transaction)
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to get group id: ", err.Error())
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
 		return err
 	}
-	rows, err := db.connection.Query(addSongQuery, group_id, name)
-	defer rows.Close()
+	db.log(ctx).Info("song successfully added")
+	return nil
+}
+
+func (db *Db) addSong(ctx context.Context, transaction pgx.Tx, group string, name string, text string, url string, date time.Time, artists []Artist, author string) error {
+	group_id, err := db.getOrAddGroupID(ctx, group, transaction)
 	if err != nil {
-		db.logger.Error("failed to add song: ", err.Error())
+		db.log(ctx).Error("failed to get group id: ", err.Error())
 		return err
 	}
-	if !rows.Next() {
-		db.logger.Error("expected 1 row in insertion query result")
+	var song_id int64
+	err = transaction.QueryRow(ctx, addSongQuery, group_id, name).Scan(&song_id)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error("expected 1 row in insertion query result")
 		return fmt.Errorf("no rows after song insertion")
+	} else if isSongExistsError(err) {
+		db.log(ctx).Error(ErrSongExists.Error())
+		return ErrSongExists
+	} else if err != nil {
+		db.log(ctx).Error("failed to add song: ", err.Error())
+		return err
 	}
-	var song_id int64
-	err = rows.Scan(&song_id)
+
+	_, err = transaction.Exec(ctx, addSongInfoQuery, song_id, text, url, date)
 	if err != nil {
-		db.logger.Error("failed to retrieve song id from query result: ", err.Error())
+		db.log(ctx).Error("failed to add song details: ", err.Error())
 		return err
 	}
-	rows.Close()
+	if err = db.setArtists(ctx, transaction, song_id, group_id, artists); err != nil {
+		return err
+	}
+	return db.addRevision(ctx, transaction, song_id, author, nil,
+		Song{Group: group, Song: name, Text: text, URL: url, ReleaseDate: date.Format(DateFmt)})
+}
 
-	_, err = db.connection.Exec(addSongInfoQuery, song_id, text, url, date.Format(internalDateFmt))
+// AddSongs inserts the songs in a single transaction. Every song is inserted under its own
+// savepoint, so a failed row doesn't abort the others. The returned slice holds the
+// result of each row, the error is set if the whole batch failed.
+func (db *Db) AddSongs(ctx context.Context, songs []Song) (_ []error, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("adding a batch of ", len(songs), " songs")
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to add song details: ", err.Error())
-		return err
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return nil, err
 	}
+	defer transaction.Rollback(context.Background())
 
-	err = transaction.Commit()
+	results := make([]error, len(songs))
+	for i, song := range songs {
+		date, err := time.Parse(DateFmt, song.ReleaseDate)
+		if song.Group == "" || song.Song == "" || song.Text == "" || song.URL == "" || err != nil || ValidateArtists(song.Artists) != nil {
+			db.log(ctx).Error("invalid song in batch, row ", i)
+			results[i] = ErrInvalidData
+			continue
+		}
+
+		savepoint, err := transaction.Begin(ctx)
+		if err != nil {
+			db.log(ctx).Error("failed to create savepoint: ", err.Error())
+			return nil, err
+		}
+		results[i] = db.addSong(ctx, savepoint, song.Group, song.Song, song.Text, song.URL, date, song.Artists, "")
+		if results[i] != nil {
+			err = savepoint.Rollback(ctx)
+		} else {
+			err = savepoint.Commit(ctx)
+		}
+		if err != nil {
+			db.log(ctx).Error("failed to release savepoint: ", err.Error())
+			return nil, err
+		}
+	}
+
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
-		return err
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return nil, err
 	}
-	db.logger.Info("song successfully added")
-	return nil
+	db.log(ctx).Info("batch successfully added")
+	return results, nil
 }
 
-func (db *Db) GetSongText(group string, song string) (string, error) {
+func (db *Db) GetSongText(ctx context.Context, group string, song string) (_ string, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
 	if group == "" || song == "" {
-		db.logger.Error("invalid use of GetSongText: one of the parameters is empty")
+		db.log(ctx).Error("invalid use of GetSongText: one of the parameters is empty")
 		return "", ErrInvalidData
 	}
 
-	db.logger.Info("searching for song, group: '", group, "', name: '", song, "'")
-	transaction, err := db.connection.Begin()
+	db.log(ctx).Info("searching for song, group: '", group, "', name: '", song, "'")
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err)
+		db.log(ctx).Error("failed to start transaction: ", err)
 		return "", err
 	}
-	defer transaction.Rollback()
+	defer transaction.Rollback(context.Background())
 
-	group_id, err := db.getGroupID(group, transaction)
+	group_id, err := db.getGroupID(ctx, group, transaction)
 	if err != nil {
 		return "", err
 	} else if group_id == -1 {
 		err = ErrGroupNotFound
-		db.logger.Error(err.Error())
+		db.log(ctx).Error(err.Error())
 		return "", err
 	}
-	rows, err := db.connection.Query(getSongTextQuery, group_id, song)
-	defer rows.Close()
-	if err != nil {
-		db.logger.Error("failed to get song text: ", err.Error())
-		return "", err
-	}
-	if !rows.Next() {
+	var text string
+	err = transaction.QueryRow(ctx, getSongTextQuery, group_id, song).Scan(&text)
+	if err == pgx.ErrNoRows {
 		err = ErrSongNotFound
-		db.logger.Error(err.Error())
+		db.log(ctx).Error(err.Error())
 		return "", err
-	}
-	var text string
-	err = rows.Scan(&text)
-	if err != nil {
-		db.logger.Error("failed to retrieve song text: ", err.Error())
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song text: ", err.Error())
 		return "", err
 	}
-	rows.Close()
 
-	err = transaction.Commit()
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
 		return "", err
 	}
 	return text, nil
 }
 
-func (db *Db) DeleteSong(song LibraryEntry) error {
+func (db *Db) GetSong(ctx context.Context, group string, song string) (_ Song, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if group == "" || song == "" {
+		db.log(ctx).Error("invalid use of GetSong: one of the parameters is empty")
+		return Song{}, ErrInvalidData
+	}
+
+	db.log(ctx).Info("retrieving song details, group: '", group, "', name: '", song, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err)
+		return Song{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	group_id, err := db.getGroupID(ctx, group, transaction)
+	if err != nil {
+		return Song{}, err
+	} else if group_id == -1 {
+		err = ErrGroupNotFound
+		db.log(ctx).Error(err.Error())
+		return Song{}, err
+	}
+	result := Song{Group: group, Song: song}
+	var song_id int64
+	var release_date time.Time
+	err = transaction.QueryRow(ctx, getSongQuery, group_id, song).Scan(&song_id, &result.Text, &result.URL, &release_date)
+	if err == pgx.ErrNoRows {
+		err = ErrSongNotFound
+		db.log(ctx).Error(err.Error())
+		return Song{}, err
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song details: ", err.Error())
+		return Song{}, err
+	}
+	result.ReleaseDate = release_date.Format(DateFmt)
+	if result.Artists, err = db.getArtists(ctx, transaction, song_id); err != nil {
+		return Song{}, err
+	}
+
+	err = transaction.Commit(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return Song{}, err
+	}
+	return result, nil
+}
+
+func (db *Db) DeleteSong(ctx context.Context, song LibraryEntry) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
 	if song.Group == "" || song.Song == "" {
-		db.logger.Error("invalid use of DeleteSong: one of the parameters is empty")
+		db.log(ctx).Error("invalid use of DeleteSong: one of the parameters is empty")
 		return ErrInvalidData
 	}
 
-	db.logger.Info("deleting song, group: '", song.Group, "', song: '", song.Song, "'")
-	transaction, err := db.connection.Begin()
+	db.log(ctx).Info("deleting song, group: '", song.Group, "', song: '", song.Song, "'")
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err)
+		db.log(ctx).Error("failed to start transaction: ", err)
 		return err
 	}
-	defer transaction.Rollback()
+	defer transaction.Rollback(context.Background())
 
-	group_id, err := db.getGroupID(song.Group, transaction)
+	group_id, err := db.getGroupID(ctx, song.Group, transaction)
 	if err != nil {
 		return err
 	} else if group_id == -1 {
 		err = ErrGroupNotFound
-		db.logger.Error(err.Error())
+		db.log(ctx).Error(err.Error())
 		return err
 	}
-	_, err = transaction.Exec(deleteSongQuery, group_id, song.Song)
+	tag, err := transaction.Exec(ctx, deleteSongQuery, group_id, song.Song)
 	if err != nil {
-		db.logger.Error("failed to delete song: ", err.Error())
+		db.log(ctx).Error("failed to delete song: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		err = ErrSongNotFound
+		db.log(ctx).Error(err.Error())
 		return err
 	}
 
-	err = transaction.Commit()
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
 		return err
 	}
-	db.logger.Info("deletion successful")
+	db.log(ctx).Info("deletion successful")
 	return nil
 }
 
-func (db *Db) GetFiltered(group, song string, page_idx, page_size uint, release_date *time.Time) (LibraryPage, error) {
-	db.logger.Info("retrieving filtered library data, group '", group,
-		"' song '", song, "', page ", page_idx, ", page size ", page_size)
-	if group == "" && song == "" && release_date == nil {
-		db.logger.Info("filter is empty")
-		return db.getAll(page_idx, page_size)
-	}
-
-	query := getLibraryFilterBase
-	count_query := getLibraryFilterCountBase
-	arg_idx := 1
-	if group != "" {
-		filter_group := fmt.Sprintf(getLibraryFilterGroupFmt, arg_idx)
-		query += filter_group
-		count_query += filter_group
-		if song != "" || release_date != nil {
-			query += " AND"
-			count_query += " AND"
-		}
-		arg_idx++
-	}
-	if song != "" {
-		filter_song := fmt.Sprintf(getLibraryFilterSongFmt, arg_idx)
-		query += filter_song
-		count_query += filter_song
-		arg_idx++
-		if release_date != nil {
-			query += " AND"
-			count_query += " AND"
-		}
-	}
-	if release_date != nil {
-		filter_date := fmt.Sprintf(getLibraryFilterReleaseDateFmt, arg_idx)
-		query += filter_date
-		count_query += filter_date
-		arg_idx++
+func (db *Db) GetFiltered(ctx context.Context, filter LibraryFilter, page_idx, page_size uint) (_ LibraryPage, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("retrieving filtered library data, groups ", filter.Groups,
+		" song '", filter.Song, "', page ", page_idx, ", page size ", page_size)
+	order := filter.order()
+	if filter.empty() && isDefaultOrder(order) {
+		db.log(ctx).Info("filter is empty")
+		return db.getAll(ctx, page_idx, page_size)
 	}
-	query += fmt.Sprintf(getLibraryFilterPaginationFmt, arg_idx, arg_idx+1)
-	count_query += getLibraryFilterCountEnd
-	db.logger.Debug("resulting query: ", query)
 
-	transaction, err := db.connection.Begin()
+	builder := sqlbuilder.Builder{}
+	addFilter(&builder, filter)
+	count_query := getLibraryFilterCountBase + builder.And() + ";"
+	count_args := builder.Args()
+	query := getLibraryFilterBase + builder.And() + orderBy(order, false) +
+		" LIMIT " + builder.Param(page_size) + " OFFSET " + builder.Param(page_idx*page_size) + ";"
+	db.log(ctx).Debug("resulting query: ", query)
+
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err.Error())
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
 		return LibraryPage{}, err
 	}
-	defer transaction.Rollback()
+	defer transaction.Rollback(context.Background())
 
 	// validate page index
-	var count_rows *pgx.Rows
-	if group == "" && release_date == nil {
-		count_rows, err = transaction.Query(count_query, song)
-	} else if song == "" && release_date == nil {
-		count_rows, err = transaction.Query(count_query, group)
-	} else if release_date == nil {
-		count_rows, err = transaction.Query(count_query, group, song)
-	} else if group == "" && song == "" {
-		count_rows, err = transaction.Query(count_query, *release_date)
-	} else {
-		count_rows, err = transaction.Query(count_query, group, song, *release_date)
-	}
-	defer count_rows.Close()
-	if err != nil {
-		db.logger.Error("failed to get library entries count: ", err.Error())
+	count, err := db.getCount(ctx, transaction, count_query, count_args...)
+	if err != nil {
 		return LibraryPage{}, err
 	}
-	page_count, err := db.validatePageIndex(count_rows, page_idx, page_size)
+	page_count, err := db.validatePageIndex(ctx, count, page_idx, page_size)
 	if err != nil {
 		return LibraryPage{}, err
 	}
-	count_rows.Close()
 
 	// get data
-	var rows *pgx.Rows
-	if group == "" && release_date == nil {
-		rows, err = transaction.Query(query, song, page_size, page_idx*page_size)
-	} else if song == "" && release_date == nil {
-		rows, err = transaction.Query(query, group, page_size, page_idx*page_size)
-	} else if release_date == nil {
-		rows, err = transaction.Query(query, group, song, page_size, page_idx*page_size)
-	} else if group == "" && song == "" {
-		rows, err = transaction.Query(query, release_date, page_size, page_idx*page_size)
-	} else {
-		rows, err = transaction.Query(query, group, song, release_date.Format(internalDateFmt), page_size, page_idx*page_size)
+	rows, err := transaction.Query(ctx, query, builder.Args()...)
+	if err != nil {
+		db.log(ctx).Error("failed to retrieve library: ", err.Error())
+		return LibraryPage{}, err
 	}
-	defer rows.Close()
+
+	result := LibraryPage{PageCount: &page_count, PageIndex: &page_idx}
+	if err = db.readLibraryEntries(ctx, rows, &result); err != nil {
+		return LibraryPage{}, err
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return LibraryPage{}, err
+	}
+	return result, nil
+}
+
+// GetFilteredByCursor returns a page of the library ordered like GetFiltered, starting right after
+// (or ending right before, for backward cursors) the song the cursor points at. Unlike page indexes,
+// cursors stay valid when songs are added or deleted before them.
+func (db *Db) GetFilteredByCursor(ctx context.Context, filter LibraryFilter, cursor string, page_size uint, with_count bool) (_ LibraryPage, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("retrieving library data by cursor, groups ", filter.Groups,
+		" song '", filter.Song, "', page size ", page_size)
+	order := filter.order()
+	position, has_cursor, err := decodeCursor(cursor, order)
 	if err != nil {
-		db.logger.Error("failed to retrieve library: ", err.Error())
+		db.log(ctx).Error("failed to decode cursor '", cursor, "'")
 		return LibraryPage{}, err
+	} else if page_size == 0 {
+		db.log(ctx).Error("page size must be non-zero")
+		return LibraryPage{}, ErrInvalidData
 	}
 
-	result := LibraryPage{PageCount: page_count, PageIndex: page_idx}
-	buffer := LibraryEntry{}
+	builder := sqlbuilder.Builder{}
+	addFilter(&builder, filter)
+	count_query := getLibraryCursorCountBase + builder.And() + ";"
+	count_args := builder.Args()
+	if has_cursor {
+		addAfter(&builder, order, position.key(), position.Backward)
+	}
+	// one more row tells if there is a page after this one
+	query := getLibraryCursorBase + builder.And() + orderBy(order, position.Backward) +
+		" LIMIT " + builder.Param(page_size+1) + ";"
+	db.log(ctx).Debug("resulting query: ", query)
+
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return LibraryPage{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	var count int64
+	if with_count {
+		if count, err = db.getCount(ctx, transaction, count_query, count_args...); err != nil {
+			return LibraryPage{}, err
+		}
+	}
+
+	rows, err := transaction.Query(ctx, query, builder.Args()...)
+	if err != nil {
+		db.log(ctx).Error("failed to retrieve library: ", err.Error())
+		return LibraryPage{}, err
+	}
+	entries := LibraryPage{}
+	if err = db.readLibraryEntries(ctx, rows, &entries); err != nil {
+		return LibraryPage{}, err
+	}
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return LibraryPage{}, err
+	}
+
+	has_more := uint(len(entries.Entries)) > page_size
+	if has_more {
+		entries.Entries = entries.Entries[:page_size]
+	}
+	if position.Backward {
+		slices.Reverse(entries.Entries)
+	}
+	result := cursorPage(entries.Entries, order, position, has_cursor, has_more)
+	if with_count {
+		result.Count = &count
+	}
+	return result, nil
+}
+
+// ExportSongs calls emit for every song matching the filter, ordered like GetFiltered.
+// Rows are read through a server-side cursor in fixed-size chunks, so the library is
+// never loaded into memory at once. Iteration stops at the first error returned by emit.
+// The query timeout doesn't apply: an export takes as long as the client needs to read it.
+func (db *Db) ExportSongs(ctx context.Context, filter LibraryFilter, emit func(Song) error) (err error) {
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("exporting library, groups ", filter.Groups, " song '", filter.Song, "'")
+	builder := sqlbuilder.Builder{}
+	addFilter(&builder, filter)
+	query := exportBase + builder.And() + orderBy(filter.order(), false) + ";"
+	db.log(ctx).Debug("resulting query: ", query)
+
+	// cursors only live inside a transaction
+	transaction, err := db.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return err
+	}
+	defer transaction.Rollback(context.Background())
+
+	if _, err = transaction.Exec(ctx, query, builder.Args()...); err != nil {
+		db.log(ctx).Error("failed to declare export cursor: ", err.Error())
+		return err
+	}
+
+	exported := 0
+	for {
+		rows, err := transaction.Query(ctx, exportFetch)
+		if err != nil {
+			db.log(ctx).Error("failed to fetch exported rows: ", err.Error())
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			var result Song
+			var date time.Time
+			if err = rows.Scan(&result.Group, &result.Song, &result.Text, &result.URL, &date); err != nil {
+				rows.Close()
+				db.log(ctx).Error("failed to read exported row: ", err.Error())
+				return err
+			}
+			result.ReleaseDate = date.Format(DateFmt)
+			if err = emit(result); err != nil {
+				rows.Close()
+				db.log(ctx).Error("export interrupted: ", err.Error())
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			db.log(ctx).Error("failed to fetch exported rows: ", err.Error())
+			return err
+		}
+		exported += fetched
+		if fetched == 0 {
+			break
+		}
+	}
+
+	if _, err = transaction.Exec(ctx, exportCursorClose); err != nil {
+		db.log(ctx).Error("failed to close export cursor: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("exported ", exported, " songs")
+	return nil
+}
+
+// Search looks for songs whose lyrics match the query (websearch_to_tsquery syntax),
+// most relevant first. Snippets have the matching words wrapped in <b></b>.
+func (db *Db) Search(ctx context.Context, query string, page_idx, page_size uint) (_ SearchPage, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if query == "" {
+		db.log(ctx).Error("invalid use of Search: query is empty")
+		return SearchPage{}, ErrEmptyFilter
+	}
+
+	db.log(ctx).Info("searching lyrics, query '", query, "', page ", page_idx, ", page size ", page_size)
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return SearchPage{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	count, err := db.getCount(ctx, transaction, searchCountQuery, query)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	page_count, err := db.validatePageIndex(ctx, count, page_idx, page_size)
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	rows, err := transaction.Query(ctx, searchQuery, query, page_size, page_idx*page_size)
+	if err != nil {
+		db.log(ctx).Error("failed to search lyrics: ", err.Error())
+		return SearchPage{}, err
+	}
+	defer rows.Close()
+	result := SearchPage{PageCount: page_count, PageIndex: page_idx}
+	buffer := SearchResult{}
 	time_buffer := time.Time{}
 	for rows.Next() {
-		err = rows.Scan(&buffer.Group, &buffer.Song, &time_buffer)
+		err = rows.Scan(&buffer.Group, &buffer.Song, &time_buffer, &buffer.Rank, &buffer.Snippet)
 		if err != nil {
-			db.logger.Error("failed to retrieve library entry: ", err.Error(), ", retrieved: ", len(result.Entries))
-			return LibraryPage{}, err
+			db.log(ctx).Error("failed to retrieve search result: ", err.Error(), ", retrieved: ", len(result.Entries))
+			return SearchPage{}, err
 		}
 		buffer.ReleaseDate = time_buffer.Format(DateFmt)
-		db.logger.Debug("adding entry: group '", buffer.Group, "', song '", buffer.Song, "'")
 		result.Entries = append(result.Entries, buffer)
 	}
-	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to search lyrics: ", err.Error())
+		return SearchPage{}, err
+	}
 
-	if err = transaction.Commit(); err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
-		return LibraryPage{}, err
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return SearchPage{}, err
 	}
 	return result, nil
 }
 
-func (db *Db) UpdateSong(song LibraryEntry, new_group, new_name, new_text, new_url string, new_release_date *time.Time) error {
+// UpdateSong changes the given song details and records the change as a revision by author.
+// The credits are replaced with the group and new_artists unless new_artists is nil,
+// they aren't recorded in revisions.
+func (db *Db) UpdateSong(ctx context.Context, song LibraryEntry, new_group, new_name, new_text, new_url string, new_release_date *time.Time, new_artists []Artist, author string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
 	if song.Group == "" || song.Song == "" {
-		db.logger.Error("invalid use of UpdateSong: group and/or song name is empty")
+		db.log(ctx).Error("invalid use of UpdateSong: group and/or song name is empty")
 		return ErrInvalidData
-	} else if new_group == "" && new_name == "" && new_text == "" && new_url == "" && new_release_date == nil {
+	} else if err = ValidateArtists(new_artists); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+	details_changed := new_group != "" || new_name != "" || new_text != "" || new_url != "" || new_release_date != nil
+	if !details_changed && new_artists == nil {
 		// nothing to update
-		db.logger.Debug("empty update: group '", song.Group, "', song '", song.Song, "'")
+		db.log(ctx).Debug("empty update: group '", song.Group, "', song '", song.Song, "'")
 		return nil
 	}
 
-	db.logger.Info("updating song '", song.Song, "', group '", song.Group, "'")
-	transaction, err := db.connection.Begin()
+	db.log(ctx).Info("updating song '", song.Song, "', group '", song.Group, "'")
+	transaction, err := db.begin(ctx)
 	if err != nil {
-		db.logger.Error("failed to start transaction: ", err.Error())
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
 		return err
 	}
-	defer transaction.Rollback()
+	defer transaction.Rollback(context.Background())
 
-	// get song id
-	rows, err := transaction.Query(getSongIdQuery, song.Song, song.Group)
-	defer rows.Close()
-	if err != nil {
-		db.logger.Error("failed to get song id: ", err.Error())
-		return err
-	}
-	if !rows.Next() {
-		db.logger.Error(ErrSongNotFound.Error())
-		return err
-	}
-	var song_id int64
-	err = rows.Scan(&song_id)
+	// get song id and the state before the update
+	song_id, previous, err := db.getSongState(ctx, transaction, song.Group, song.Song)
 	if err != nil {
-		db.logger.Error("failed to retrieve song id: ", err.Error())
 		return err
 	}
-	rows.Close()
 
 	// update group and/or song name
+	var new_group_id int64 = -1
 	if new_group != "" || new_name != "" {
-		db.logger.Info("updating song name and/or group. New name: '",
+		db.log(ctx).Info("updating song name and/or group. New name: '",
 			new_name, "', new group: '", new_group, "'")
-		update_song_query := updateSongBase
-		arg_idx := 1
-		var new_group_id int64
+		update := sqlbuilder.Builder{}
 		if new_group != "" {
-			db.logger.Info("new group: '", new_group, "'")
-			new_group_id, err = db.getOrAddGroupID(new_group, transaction)
+			db.log(ctx).Info("new group: '", new_group, "'")
+			new_group_id, err = db.getOrAddGroupID(ctx, new_group, transaction)
 			if err != nil {
-				db.logger.Error("failed to get new group id: ", err.Error())
+				db.log(ctx).Error("failed to get new group id: ", err.Error())
 				return err
 			}
-			update_song_query += fmt.Sprintf(updateSongGroupFmt, arg_idx)
-			if new_name != "" {
-				update_song_query += ","
-			}
-			arg_idx++
+			update.Set("group_id", new_group_id)
 		}
 		if new_name != "" {
-			db.logger.Info("new song name: '", new_name, "'")
-			update_song_query += fmt.Sprintf(updateSongNameFmt, arg_idx)
-			arg_idx++
+			db.log(ctx).Info("new song name: '", new_name, "'")
+			update.Set("song_name", new_name)
 		}
+		update.Where("id = " + update.Param(song_id))
+		query := update.Update(updateSongTable)
+		db.log(ctx).Debug("resulting query: ", query)
 
-		update_song_query += fmt.Sprintf(updateSongEndFmt, arg_idx)
-		db.logger.Debug("resulting query: ", update_song_query)
-
-		if new_group == "" {
-			_, err = transaction.Exec(update_song_query, new_name, song_id)
-		} else if new_name == "" {
-			_, err = transaction.Exec(update_song_query, new_group_id, song_id)
-		} else {
-			_, err = transaction.Exec(update_song_query, new_group_id, new_name, song_id)
-		}
-		if err != nil {
-			db.logger.Error("failed to update song: ", err.Error())
+		_, err = transaction.Exec(ctx, query, update.Args()...)
+		if isSongExistsError(err) {
+			db.log(ctx).Error(ErrSongExists.Error())
+			return ErrSongExists
+		} else if err != nil {
+			db.log(ctx).Error("failed to update song: ", err.Error())
 			return err
 		}
+		// albums only have songs of their group as tracks
+		if new_group != "" && new_group != previous.Group {
+			if _, err = transaction.Exec(ctx, leaveAlbumQuery, song_id); err != nil {
+				db.log(ctx).Error("failed to remove album track: ", err.Error())
+				return err
+			}
+		}
 	}
 
-	//update song info
+	// update song info
 	if new_text != "" || new_url != "" || new_release_date != nil {
-		db.logger.Info("updating song text and/or url")
-		update_song_info_query := updateSongInfoBase
-		arg_idx := 1
+		db.log(ctx).Info("updating song text, url and/or release date")
+		update := sqlbuilder.Builder{}
 		if new_text != "" {
-			update_song_info_query += fmt.Sprintf(updateSongInfoTextFmt, arg_idx)
-			if new_url != "" || new_release_date != nil {
-				update_song_info_query += ","
-			}
-			arg_idx++
+			update.Set("lyrics", new_text)
 		}
 		if new_url != "" {
-			update_song_info_query += fmt.Sprintf(updateSongInfoUrlFmt, arg_idx)
-			if new_release_date != nil {
-				update_song_info_query += ","
-			}
-			arg_idx++
+			update.Set("url", new_url)
 		}
 		if new_release_date != nil {
-			db.logger.Info("new release date: ", new_release_date.Format(DateFmt))
-			update_song_info_query += fmt.Sprintf(updateSongInfoReleaseDateFmt, arg_idx)
-			arg_idx++
+			db.log(ctx).Info("new release date: ", new_release_date.Format(DateFmt))
+			update.Set("release_date", *new_release_date)
 		}
-		update_song_info_query += fmt.Sprintf(updateSongInfoEndFmt, arg_idx)
-		db.logger.Debug("resulting query: ", update_song_info_query)
-
-		if new_text == "" && new_release_date == nil {
-			_, err = transaction.Exec(update_song_info_query, new_url, song_id)
-		} else if new_url == "" && new_release_date == nil {
-			_, err = transaction.Exec(update_song_info_query, new_text, song_id)
-		} else if new_release_date == nil {
-			_, err = transaction.Exec(update_song_info_query, new_text, new_url, song_id)
-		} else if new_text == "" && new_url == "" {
-			_, err = transaction.Exec(update_song_info_query, new_release_date, song_id)
-		} else {
-			_, err = transaction.Exec(update_song_info_query, new_text, new_url, new_release_date, song_id)
+		update.Where("song_id = " + update.Param(song_id))
+		query := update.Update(updateSongInfoTable)
+		db.log(ctx).Debug("resulting query: ", query)
+
+		if _, err = transaction.Exec(ctx, query, update.Args()...); err != nil {
+			db.log(ctx).Error("failed to update song info: ", err.Error())
+			return err
 		}
-		if err != nil {
-			db.logger.Error("failed to update song info: ", err.Error())
+		// the song's own release date replaces the one inherited from its album
+		if new_release_date != nil {
+			if _, err = transaction.Exec(ctx, stopInheritingQuery, song_id); err != nil {
+				db.log(ctx).Error("failed to update album track: ", err.Error())
+				return err
+			}
+		}
+	}
+
+	// update credits
+	if new_artists != nil {
+		db.log(ctx).Info("replacing credits with ", len(new_artists), " artists")
+		group_id := new_group_id
+		if group_id == -1 {
+			if group_id, err = db.getGroupID(ctx, previous.Group, transaction); err != nil {
+				return err
+			}
+		}
+		if err = db.replaceArtists(ctx, transaction, song_id, group_id, new_artists); err != nil {
+			return err
+		}
+	} else if new_group_id != -1 {
+		if err = db.movePrimaryArtist(ctx, transaction, song_id, previous.Group, new_group_id); err != nil {
+			return err
+		}
+	}
+
+	current := previous
+	if new_group != "" {
+		current.Group = new_group
+	}
+	if new_name != "" {
+		current.Song = new_name
+	}
+	if new_text != "" {
+		current.Text = new_text
+	}
+	if new_url != "" {
+		current.URL = new_url
+	}
+	if new_release_date != nil {
+		current.ReleaseDate = new_release_date.Format(DateFmt)
+	}
+	if details_changed {
+		if err = db.addRevision(ctx, transaction, song_id, author, &previous, current); err != nil {
 			return err
 		}
 	}
 
-	err = transaction.Commit()
+	err = transaction.Commit(ctx)
 	if err != nil {
-		db.logger.Error("failed to commit transaction: ", err.Error())
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return err
+	}
+	db.log(ctx).Info("update successful")
+	return nil
+}
+
+// Ready checks that the database is reachable and the schema hasn't been changed
+// by another instance since the migrations were applied on startup.
+func (db *Db) Ready(ctx context.Context) error {
+	if err := db.pool.Ping(ctx); err != nil {
+		db.log(ctx).Error("database is unreachable: ", err.Error())
+		return err
+	}
+	var version int64
+	var dirty bool
+	if err := db.pool.QueryRow(ctx, migrationVersionQuery).Scan(&version, &dirty); err != nil {
+		db.log(ctx).Error("failed to get the database schema version: ", err.Error())
 		return err
+	} else if dirty || uint(version) != db.migration_version {
+		db.log(ctx).Error(ErrNotMigrated.Error(), ": version ", version, ", dirty: ", dirty, ", expected ", db.migration_version)
+		return ErrNotMigrated
 	}
-	db.logger.Info("update successful")
 	return nil
 }
 
 func (db *Db) Close() {
-	db.logger.Info("closing connection to the database")
-	db.connection.Close()
+	db.logger.Info("closing connection pool")
+	db.pool.Close()
 }
@@ -0,0 +1,307 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/sqlbuilder"
+)
+
+type MatchMode string
+
+const (
+	// MatchLike treats the value as a case-sensitive LIKE pattern with % and _ wildcards
+	MatchLike MatchMode = "like"
+	// MatchExact, MatchPrefix and MatchContains compare the value literally, ignoring case
+	MatchExact    MatchMode = "exact"
+	MatchPrefix   MatchMode = "prefix"
+	MatchContains MatchMode = "contains"
+)
+
+type SortColumn string
+
+const (
+	SortGroup       SortColumn = "group"
+	SortSong        SortColumn = "song"
+	SortReleaseDate SortColumn = "release_date"
+)
+
+var sortColumns = map[SortColumn]string{
+	SortGroup:       "name",
+	SortSong:        "song_name",
+	SortReleaseDate: "release_date",
+}
+
+// defaultOrder is the library order, columns missing from a requested order are appended from it
+var defaultOrder = []SortField{{Column: SortGroup}, {Column: SortSong}, {Column: SortReleaseDate}}
+
+type SortField struct {
+	Column     SortColumn
+	Descending bool
+}
+
+// LibraryFilter selects songs of the library. Zero fields don't filter.
+type LibraryFilter struct {
+	// Groups matches songs crediting any of the groups in any role
+	Groups     []string
+	GroupMatch MatchMode
+	Song       string
+	SongMatch  MatchMode
+	// ReleaseDate is the exact release date
+	ReleaseDate *time.Time
+	// ReleasedAfter and ReleasedBefore are inclusive bounds of the release date
+	ReleasedAfter  *time.Time
+	ReleasedBefore *time.Time
+	// Album and AlbumGroup are the exact title and group of the album the songs are tracks of,
+	// titles are only unique within a group
+	Album      string
+	AlbumGroup string
+	// Sort is the order of the songs, the library order by default
+	Sort []SortField
+}
+
+func (f LibraryFilter) empty() bool {
+	return len(f.Groups) == 0 && f.Song == "" && f.ReleaseDate == nil && f.ReleasedAfter == nil && f.ReleasedBefore == nil && f.Album == ""
+}
+
+// order returns the requested order completed to a total one with the default order.
+func (f LibraryFilter) order() []SortField {
+	result := make([]SortField, 0, len(defaultOrder))
+	seen := make(map[SortColumn]bool, len(defaultOrder))
+	for _, field := range append(f.Sort, defaultOrder...) {
+		if _, known := sortColumns[field.Column]; known && !seen[field.Column] {
+			seen[field.Column] = true
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// orderName is a stable name of the order, cursors remember the order they were created for.
+func orderName(order []SortField) string {
+	names := make([]string, 0, len(order))
+	for _, field := range order {
+		if field.Descending {
+			names = append(names, string(field.Column)+":desc")
+		} else {
+			names = append(names, string(field.Column))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func isDefaultOrder(order []SortField) bool {
+	return orderName(order) == orderName(defaultOrder)
+}
+
+// libraryKey holds the sortable columns of a song, the date in internalDateFmt sorts as a string.
+type libraryKey struct {
+	group string
+	song  string
+	date  string
+}
+
+func (k libraryKey) value(column SortColumn) string {
+	switch column {
+	case SortGroup:
+		return k.group
+	case SortSong:
+		return k.song
+	}
+	return k.date
+}
+
+func entryKey(entry LibraryEntry) libraryKey {
+	date, _ := time.Parse(DateFmt, entry.ReleaseDate)
+	return libraryKey{group: entry.Group, song: entry.Song, date: date.Format(internalDateFmt)}
+}
+
+func compareKeys(order []SortField, a, b libraryKey) int {
+	for _, field := range order {
+		cmp := strings.Compare(a.value(field.Column), b.value(field.Column))
+		if field.Descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// matchCondition returns the condition comparing the column to the value in the match mode.
+func matchCondition(builder *sqlbuilder.Builder, column string, value string, mode MatchMode) string {
+	switch mode {
+	case MatchExact:
+		return "lower(" + column + ") = lower(" + builder.Param(value) + ")"
+	case MatchPrefix:
+		return column + " ILIKE " + builder.Param(sqlbuilder.EscapeLike(value)+"%")
+	case MatchContains:
+		return column + " ILIKE " + builder.Param("%"+sqlbuilder.EscapeLike(value)+"%")
+	}
+	return column + " LIKE " + builder.Param(value)
+}
+
+// addFilter adds the conditions of the library filter.
+func addFilter(builder *sqlbuilder.Builder, filter LibraryFilter) {
+	if len(filter.Groups) != 0 {
+		groups := make([]string, 0, len(filter.Groups))
+		for _, group := range filter.Groups {
+			groups = append(groups, matchCondition(builder, "artists.name", group, filter.GroupMatch))
+		}
+		builder.Where("songs.id IN (SELECT song_id FROM song_artists JOIN groups AS artists" +
+			" ON artists.id = song_artists.group_id WHERE " + sqlbuilder.Or(groups...) + ")")
+	}
+	if filter.Song != "" {
+		builder.Where(matchCondition(builder, "song_name", filter.Song, filter.SongMatch))
+	}
+	if filter.ReleaseDate != nil {
+		builder.Where("release_date = " + builder.Param(*filter.ReleaseDate))
+	}
+	if filter.ReleasedAfter != nil {
+		builder.Where("release_date >= " + builder.Param(*filter.ReleasedAfter))
+	}
+	if filter.ReleasedBefore != nil {
+		builder.Where("release_date <= " + builder.Param(*filter.ReleasedBefore))
+	}
+	if filter.Album != "" {
+		builder.Where("songs.id IN (SELECT song_id FROM album_tracks JOIN albums ON albums.id = album_tracks.album_id" +
+			" JOIN groups AS album_groups ON album_groups.id = albums.group_id" +
+			" WHERE title = " + builder.Param(filter.Album) + " AND album_groups.name = " + builder.Param(filter.AlbumGroup) + ")")
+	}
+}
+
+// addAfter adds the condition selecting the songs that follow the key in the order,
+// or precede it if backward is set.
+func addAfter(builder *sqlbuilder.Builder, order []SortField, key libraryKey, backward bool) {
+	params := make([]string, len(order))
+	for i, field := range order {
+		if field.Column == SortReleaseDate {
+			params[i] = builder.Param(key.value(field.Column)) + "::date"
+		} else {
+			params[i] = builder.Param(key.value(field.Column))
+		}
+	}
+	// (a > x) OR (a = x AND b > y) OR (a = x AND b = y AND c > z) with the operators
+	// following the direction of every column
+	alternatives := make([]string, 0, len(order))
+	for i, field := range order {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, sortColumns[order[j].Column]+" = "+params[j])
+		}
+		operator := ">"
+		if field.Descending != backward {
+			operator = "<"
+		}
+		terms = append(terms, sortColumns[field.Column]+" "+operator+" "+params[i])
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	builder.Where(sqlbuilder.Or(alternatives...))
+}
+
+// orderBy returns the ORDER BY clause, reversed if backward is set.
+func orderBy(order []SortField, backward bool) string {
+	columns := make([]string, 0, len(order))
+	for _, field := range order {
+		if field.Descending != backward {
+			columns = append(columns, sortColumns[field.Column]+" DESC")
+		} else {
+			columns = append(columns, sortColumns[field.Column])
+		}
+	}
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// matcher returns the in-memory equivalent of the SQL match.
+func matcher(value string, mode MatchMode) func(string) bool {
+	lower := strings.ToLower(value)
+	switch mode {
+	case MatchExact:
+		return func(s string) bool { return strings.ToLower(s) == lower }
+	case MatchPrefix:
+		return func(s string) bool { return strings.HasPrefix(strings.ToLower(s), lower) }
+	case MatchContains:
+		return func(s string) bool { return strings.Contains(strings.ToLower(s), lower) }
+	}
+	return likeToRegexp(value).MatchString
+}
+
+// likeToRegexp converts a PostgreSQL LIKE pattern to an anchored regular expression.
+func likeToRegexp(pattern string) *regexp.Regexp {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			builder.WriteString(".*")
+		case r == '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.MustCompile(builder.String())
+}
+
+// matcher returns a function reporting whether a song with the credits passes the filter,
+// for the in-memory storage.
+func (f LibraryFilter) matcher() func(key libraryKey, artists []Artist) bool {
+	var group_matchers []func(string) bool
+	for _, group := range f.Groups {
+		group_matchers = append(group_matchers, matcher(group, f.GroupMatch))
+	}
+	var song_matcher func(string) bool
+	if f.Song != "" {
+		song_matcher = matcher(f.Song, f.SongMatch)
+	}
+	format := func(date *time.Time) string {
+		if date == nil {
+			return ""
+		}
+		return date.Format(internalDateFmt)
+	}
+	exact, after, before := format(f.ReleaseDate), format(f.ReleasedAfter), format(f.ReleasedBefore)
+
+	return func(key libraryKey, artists []Artist) bool {
+		if len(group_matchers) != 0 && !anyCredited(group_matchers, key.group, artists) {
+			return false
+		} else if song_matcher != nil && !song_matcher(key.song) {
+			return false
+		} else if exact != "" && key.date != exact {
+			return false
+		} else if after != "" && key.date < after {
+			return false
+		}
+		return before == "" || key.date <= before
+	}
+}
+
+func anyMatches(matchers []func(string) bool, value string) bool {
+	for _, match := range matchers {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyCredited(matchers []func(string) bool, group string, artists []Artist) bool {
+	if anyMatches(matchers, group) {
+		return true
+	}
+	for _, artist := range artists {
+		if anyMatches(matchers, artist.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/sqlbuilder"
+)
+
+func TestAddFilter(t *testing.T) {
+	date := time.Date(2006, 1, 18, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter LibraryFilter
+		sql    string
+		args   []any
+	}{
+		{
+			name:   "empty",
+			filter: LibraryFilter{},
+			sql:    "",
+		},
+		{
+			name:   "groups",
+			filter: LibraryFilter{Groups: []string{"Muse", "Queen"}, GroupMatch: MatchExact},
+			sql: " AND songs.id IN (SELECT song_id FROM song_artists JOIN groups AS artists" +
+				" ON artists.id = song_artists.group_id WHERE (lower(artists.name) = lower($1) OR lower(artists.name) = lower($2)))",
+			args: []any{"Muse", "Queen"},
+		},
+		{
+			name:   "song prefix",
+			filter: LibraryFilter{Song: "50%", SongMatch: MatchPrefix},
+			sql:    " AND song_name ILIKE $1",
+			args:   []any{`50\%%`},
+		},
+		{
+			name:   "song contains",
+			filter: LibraryFilter{Song: "a_b", SongMatch: MatchContains},
+			sql:    " AND song_name ILIKE $1",
+			args:   []any{`%a\_b%`},
+		},
+		{
+			name:   "song like",
+			filter: LibraryFilter{Song: "S%"},
+			sql:    " AND song_name LIKE $1",
+			args:   []any{"S%"},
+		},
+		{
+			name:   "dates",
+			filter: LibraryFilter{ReleaseDate: &date, ReleasedAfter: &date, ReleasedBefore: &date},
+			sql:    " AND release_date = $1 AND release_date >= $2 AND release_date <= $3",
+			args:   []any{date, date, date},
+		},
+		{
+			name:   "album",
+			filter: LibraryFilter{Album: "Origin", AlbumGroup: "Muse"},
+			sql: " AND songs.id IN (SELECT song_id FROM album_tracks JOIN albums ON albums.id = album_tracks.album_id" +
+				" JOIN groups AS album_groups ON album_groups.id = albums.group_id WHERE title = $1 AND album_groups.name = $2)",
+			args: []any{"Origin", "Muse"},
+		},
+		{
+			name:   "combined",
+			filter: LibraryFilter{Groups: []string{"Muse"}, Song: "Uprising", SongMatch: MatchExact, ReleasedAfter: &date},
+			sql: " AND songs.id IN (SELECT song_id FROM song_artists JOIN groups AS artists" +
+				" ON artists.id = song_artists.group_id WHERE artists.name LIKE $1)" +
+				" AND lower(song_name) = lower($2) AND release_date >= $3",
+			args: []any{"Muse", "Uprising", date},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := sqlbuilder.Builder{}
+			addFilter(&builder, test.filter)
+			if sql := builder.And(); sql != test.sql {
+				t.Errorf("sql = %q, want %q", sql, test.sql)
+			}
+			if !reflect.DeepEqual(builder.Args(), test.args) {
+				t.Errorf("args = %v, want %v", builder.Args(), test.args)
+			}
+		})
+	}
+}
+
+func TestAddAfter(t *testing.T) {
+	key := libraryKey{group: "Muse", song: "Uprising", date: "2009-09-07"}
+	tests := []struct {
+		name     string
+		order    []SortField
+		backward bool
+		sql      string
+		args     []any
+	}{
+		{
+			name:  "single column",
+			order: []SortField{{Column: SortSong}},
+			sql:   " AND (song_name > $1)",
+			args:  []any{"Uprising"},
+		},
+		{
+			name:  "default order",
+			order: defaultOrder,
+			sql: " AND ((name > $1) OR (name = $1 AND song_name > $2)" +
+				" OR (name = $1 AND song_name = $2 AND release_date > $3::date))",
+			args: []any{"Muse", "Uprising", "2009-09-07"},
+		},
+		{
+			name:  "descending",
+			order: []SortField{{Column: SortReleaseDate, Descending: true}, {Column: SortGroup}},
+			sql:   " AND ((release_date < $1::date) OR (release_date = $1::date AND name > $2))",
+			args:  []any{"2009-09-07", "Muse"},
+		},
+		{
+			name:     "backward",
+			order:    []SortField{{Column: SortReleaseDate, Descending: true}, {Column: SortGroup}},
+			backward: true,
+			sql:      " AND ((release_date > $1::date) OR (release_date = $1::date AND name < $2))",
+			args:     []any{"2009-09-07", "Muse"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := sqlbuilder.Builder{}
+			addAfter(&builder, test.order, key, test.backward)
+			if sql := builder.And(); sql != test.sql {
+				t.Errorf("sql = %q, want %q", sql, test.sql)
+			}
+			if !reflect.DeepEqual(builder.Args(), test.args) {
+				t.Errorf("args = %v, want %v", builder.Args(), test.args)
+			}
+		})
+	}
+}
+
+func TestAddFilterAndAfterNumbering(t *testing.T) {
+	builder := sqlbuilder.Builder{}
+	addFilter(&builder, LibraryFilter{Song: "U%"})
+	addAfter(&builder, []SortField{{Column: SortGroup}}, libraryKey{group: "Muse"}, false)
+	want := " AND song_name LIKE $1 AND (name > $2)"
+	if sql := builder.And(); sql != want {
+		t.Errorf("sql = %q, want %q", sql, want)
+	}
+	if args := builder.Args(); !reflect.DeepEqual(args, []any{"U%", "Muse"}) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []SortField
+		backward bool
+		want     string
+	}{
+		{"default", defaultOrder, false, " ORDER BY name, song_name, release_date"},
+		{"default backward", defaultOrder, true, " ORDER BY name DESC, song_name DESC, release_date DESC"},
+		{"descending", []SortField{{Column: SortReleaseDate, Descending: true}, {Column: SortSong}}, false,
+			" ORDER BY release_date DESC, song_name"},
+		{"descending backward", []SortField{{Column: SortReleaseDate, Descending: true}, {Column: SortSong}}, true,
+			" ORDER BY release_date, song_name DESC"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := orderBy(test.order, test.backward); got != test.want {
+				t.Errorf("orderBy = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a song ingestion request processed in the background.
+type Job struct {
+	ID        string    `json:"id"`
+	Group     string    `json:"group"`
+	Song      string    `json:"song"`
+	Artists   []Artist  `json:"artists,omitempty"`
+	Status    JobStatus `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (db *Db) AddJob(ctx context.Context, job Job) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("adding ingestion job ", job.ID)
+	artists := job.Artists
+	if artists == nil {
+		artists = []Artist{}
+	}
+	_, err = db.pool.Exec(ctx, addJobQuery, job.ID, job.Group, job.Song, string(job.Status), job.CreatedAt, artists)
+	if err != nil {
+		db.log(ctx).Error("failed to add ingestion job: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (db *Db) UpdateJobStatus(ctx context.Context, id string, status JobStatus, reason string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("setting ingestion job ", id, " status to ", status)
+	tag, err := db.pool.Exec(ctx, updateJobQuery, id, string(status), reason)
+	if err != nil {
+		db.log(ctx).Error("failed to update ingestion job: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		db.log(ctx).Error(ErrJobNotFound.Error())
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func scanJob(row pgx.Row) (Job, error) {
+	job := Job{}
+	var status string
+	err := row.Scan(&job.ID, &job.Group, &job.Song, &status, &job.Error, &job.CreatedAt, &job.UpdatedAt, &job.Artists)
+	job.Status = JobStatus(status)
+	return job, err
+}
+
+func (db *Db) GetJob(ctx context.Context, id string) (_ Job, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	job, err := scanJob(db.pool.QueryRow(ctx, getJobQuery, id))
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrJobNotFound.Error())
+		return Job{}, ErrJobNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get ingestion job: ", err.Error())
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// GetPendingJobs returns queued and running jobs, oldest first.
+func (db *Db) GetPendingJobs(ctx context.Context) (_ []Job, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	rows, err := db.pool.Query(ctx, getPendingJobsQuery)
+	if err != nil {
+		db.log(ctx).Error("failed to get pending ingestion jobs: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	var result []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			db.log(ctx).Error("failed to read pending ingestion job: ", err.Error())
+			return nil, err
+		}
+		result = append(result, job)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get pending ingestion jobs: ", err.Error())
+		return nil, err
+	}
+	return result, nil
+}
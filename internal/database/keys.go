@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// APIKey describes an issued API key. The key itself is never stored, only its hash.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Role      Role       `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (db *Db) AddKey(ctx context.Context, key APIKey, hash string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("adding API key ", key.ID, " for '", key.Name, "', role ", key.Role)
+	_, err = db.pool.Exec(ctx, addKeyQuery, key.ID, key.Name, string(key.Role), hash, key.CreatedAt)
+	if err != nil {
+		db.log(ctx).Error("failed to add API key: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func scanKey(row pgx.Row) (APIKey, error) {
+	key := APIKey{}
+	var role string
+	err := row.Scan(&key.ID, &key.Name, &role, &key.CreatedAt, &key.RevokedAt)
+	key.Role = Role(role)
+	return key, err
+}
+
+// GetKeyByHash returns the active key with the given hash.
+func (db *Db) GetKeyByHash(ctx context.Context, hash string) (_ APIKey, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	key, err := scanKey(db.pool.QueryRow(ctx, getKeyByHashQuery, hash))
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrKeyNotFound.Error())
+		return APIKey{}, ErrKeyNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get API key: ", err.Error())
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetKeys returns all keys including the revoked ones, oldest first.
+func (db *Db) GetKeys(ctx context.Context) (_ []APIKey, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	rows, err := db.pool.Query(ctx, getKeysQuery)
+	if err != nil {
+		db.log(ctx).Error("failed to get API keys: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]APIKey, 0)
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			db.log(ctx).Error("failed to read API key: ", err.Error())
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get API keys: ", err.Error())
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *Db) RevokeKey(ctx context.Context, id string) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("revoking API key ", id)
+	tag, err := db.pool.Exec(ctx, revokeKeyQuery, id)
+	if err != nil {
+		db.log(ctx).Error("failed to revoke API key: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		db.log(ctx).Error(ErrKeyNotFound.Error())
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,1079 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+type songKey struct {
+	group string
+	name  string
+}
+
+type memorySong struct {
+	text         string
+	url          string
+	release_date time.Time
+	revisions    []Revision
+	// track is nil if the song isn't on an album
+	track *memoryTrack
+	// artists are the credits besides the group as the primary artist
+	artists []Artist
+}
+
+type albumKey struct {
+	group string
+	title string
+}
+
+type memoryAlbum struct {
+	release_date time.Time
+	cover_url    string
+}
+
+type memoryTrack struct {
+	album                albumKey
+	number               int
+	inherit_release_date bool
+}
+
+func (s *memorySong) state(key songKey) Song {
+	return Song{Group: key.group, Song: key.name, Text: s.text, URL: s.url, ReleaseDate: s.release_date.Format(DateFmt)}
+}
+
+// librarySong is a copy of a song taken to sort and page it without holding the lock.
+type librarySong struct {
+	key  libraryKey
+	song Song
+}
+
+// addRevision records the change of the song from previous (nil for a new song) to its current state.
+func (s *memorySong) addRevision(key songKey, author string, previous *Song) {
+	s.revisions = append(s.revisions, Revision{
+		Number:    len(s.revisions) + 1,
+		Author:    author,
+		CreatedAt: time.Now(),
+		Previous:  previous,
+		Current:   s.state(key),
+	})
+}
+
+// MemoryDb is an in-memory SongRepository. It mirrors the error semantics of Db,
+// but keeps all data in process memory and loses it on restart.
+type MemoryDb struct {
+	mutex  sync.RWMutex
+	groups map[string]struct{}
+	songs  map[songKey]*memorySong
+	// trash holds deleted songs, most recently deleted last
+	trash []memoryTrashEntry
+	jobs  map[string]Job
+	// keys maps key hashes to keys
+	keys   map[string]APIKey
+	albums map[albumKey]*memoryAlbum
+	logger *logger.Logger
+}
+
+type memoryTrashEntry struct {
+	key        songKey
+	song       *memorySong
+	deleted_at time.Time
+}
+
+func NewMemoryDb(logger *logger.Logger) *MemoryDb {
+	return &MemoryDb{
+		groups: make(map[string]struct{}),
+		songs:  make(map[songKey]*memorySong),
+		jobs:   make(map[string]Job),
+		keys:   make(map[string]APIKey),
+		albums: make(map[albumKey]*memoryAlbum),
+		logger: logger,
+	}
+}
+
+func (db *MemoryDb) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, db.logger)
+}
+
+func (db *MemoryDb) AddSong(ctx context.Context, group string, name string, text string, url string, date time.Time, artists []Artist, author string) error {
+	if group == "" || name == "" || text == "" || url == "" {
+		db.log(ctx).Error("invalid use of AddSong: one of the parameters is empty")
+		return ErrInvalidData
+	} else if err := ValidateArtists(artists); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+
+	db.log(ctx).Info("adding song, group name: '", group, "' song name: '", name, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := songKey{group: group, name: name}
+	if _, exists := db.songs[key]; exists {
+		db.log(ctx).Error(ErrSongExists.Error())
+		return ErrSongExists
+	}
+	db.groups[group] = struct{}{}
+	db.addArtists(artists)
+	added := &memorySong{text: text, url: url, release_date: date, artists: slices.Clone(artists)}
+	added.addRevision(key, author, nil)
+	db.songs[key] = added
+	db.log(ctx).Info("song successfully added")
+	return nil
+}
+
+func (db *MemoryDb) AddSongs(ctx context.Context, songs []Song) ([]error, error) {
+	db.log(ctx).Info("adding a batch of ", len(songs), " songs")
+	results := make([]error, len(songs))
+	for i, song := range songs {
+		date, err := time.Parse(DateFmt, song.ReleaseDate)
+		if err != nil {
+			db.log(ctx).Error("invalid song in batch, row ", i)
+			results[i] = ErrInvalidData
+			continue
+		}
+		results[i] = db.AddSong(ctx, song.Group, song.Song, song.Text, song.URL, date, song.Artists, "")
+	}
+	return results, nil
+}
+
+func (db *MemoryDb) GetSongText(ctx context.Context, group string, song string) (string, error) {
+	if group == "" || song == "" {
+		db.log(ctx).Error("invalid use of GetSongText: one of the parameters is empty")
+		return "", ErrInvalidData
+	}
+
+	db.log(ctx).Info("searching for song, group: '", group, "', name: '", song, "'")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, exists := db.groups[group]; !exists {
+		db.log(ctx).Error(ErrGroupNotFound.Error())
+		return "", ErrGroupNotFound
+	}
+	data, exists := db.songs[songKey{group: group, name: song}]
+	if !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return "", ErrSongNotFound
+	}
+	return data.text, nil
+}
+
+func (db *MemoryDb) GetSong(ctx context.Context, group string, song string) (Song, error) {
+	if group == "" || song == "" {
+		db.log(ctx).Error("invalid use of GetSong: one of the parameters is empty")
+		return Song{}, ErrInvalidData
+	}
+
+	db.log(ctx).Info("retrieving song details, group: '", group, "', name: '", song, "'")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, exists := db.groups[group]; !exists {
+		db.log(ctx).Error(ErrGroupNotFound.Error())
+		return Song{}, ErrGroupNotFound
+	}
+	data, exists := db.songs[songKey{group: group, name: song}]
+	if !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return Song{}, ErrSongNotFound
+	}
+	result := data.state(songKey{group: group, name: song})
+	result.Artists = creditedArtists(group, data.artists)
+	return result, nil
+}
+
+func (db *MemoryDb) DeleteSong(ctx context.Context, song LibraryEntry) error {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of DeleteSong: one of the parameters is empty")
+		return ErrInvalidData
+	}
+
+	db.log(ctx).Info("deleting song, group: '", song.Group, "', song: '", song.Song, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.groups[song.Group]; !exists {
+		db.log(ctx).Error(ErrGroupNotFound.Error())
+		return ErrGroupNotFound
+	}
+	key := songKey{group: song.Group, name: song.Song}
+	if _, exists := db.songs[key]; !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return ErrSongNotFound
+	}
+	db.trash = append(db.trash, memoryTrashEntry{key: key, song: db.songs[key], deleted_at: time.Now()})
+	delete(db.songs, key)
+	db.log(ctx).Info("deletion successful")
+	return nil
+}
+
+// filterLibrary returns the songs matching the filter in the requested order.
+func (db *MemoryDb) filterLibrary(filter LibraryFilter, order []SortField) []librarySong {
+	matches := filter.matcher()
+	db.mutex.RLock()
+	album := albumKey{group: filter.AlbumGroup, title: filter.Album}
+	songs := make([]librarySong, 0, len(db.songs))
+	for key, data := range db.songs {
+		if filter.Album != "" && (data.track == nil || data.track.album != album) {
+			continue
+		}
+		library_key := libraryKey{group: key.group, song: key.name, date: data.release_date.Format(internalDateFmt)}
+		if matches(library_key, data.artists) {
+			songs = append(songs, librarySong{key: library_key, song: data.state(key)})
+		}
+	}
+	db.mutex.RUnlock()
+
+	slices.SortFunc(songs, func(a, b librarySong) int {
+		return compareKeys(order, a.key, b.key)
+	})
+	return songs
+}
+
+func libraryEntries(songs []librarySong) []LibraryEntry {
+	if len(songs) == 0 {
+		return nil
+	}
+	entries := make([]LibraryEntry, 0, len(songs))
+	for _, song := range songs {
+		entries = append(entries, LibraryEntry{Group: song.song.Group, Song: song.song.Song, ReleaseDate: song.song.ReleaseDate})
+	}
+	return entries
+}
+
+func (db *MemoryDb) GetFiltered(ctx context.Context, filter LibraryFilter, page_idx, page_size uint) (LibraryPage, error) {
+	db.log(ctx).Info("retrieving filtered library data, groups ", filter.Groups,
+		" song '", filter.Song, "', page ", page_idx, ", page size ", page_size)
+
+	songs := db.filterLibrary(filter, filter.order())
+	page_count, err := countPages(int64(len(songs)), page_idx, page_size)
+	if err == ErrInvalidData {
+		db.log(ctx).Error("page size must be non-zero")
+		return LibraryPage{}, err
+	} else if err != nil {
+		db.log(ctx).Error("page ", page_idx, " out of bounds, max page: ", page_count)
+		return LibraryPage{}, err
+	}
+
+	start := min(int(page_idx*page_size), len(songs))
+	end := min(start+int(page_size), len(songs))
+	return LibraryPage{PageCount: &page_count, PageIndex: &page_idx, Entries: libraryEntries(songs[start:end])}, nil
+}
+
+func (db *MemoryDb) GetFilteredByCursor(ctx context.Context, filter LibraryFilter, cursor string, page_size uint, with_count bool) (LibraryPage, error) {
+	db.log(ctx).Info("retrieving library data by cursor, groups ", filter.Groups,
+		" song '", filter.Song, "', page size ", page_size)
+	order := filter.order()
+	position, has_cursor, err := decodeCursor(cursor, order)
+	if err != nil {
+		db.log(ctx).Error("failed to decode cursor '", cursor, "'")
+		return LibraryPage{}, err
+	} else if page_size == 0 {
+		db.log(ctx).Error("page size must be non-zero")
+		return LibraryPage{}, ErrInvalidData
+	}
+
+	songs := db.filterLibrary(filter, order)
+	// [start, end) are the songs on the cursor side of the position
+	start, end := 0, len(songs)
+	if has_cursor {
+		boundary, found := slices.BinarySearchFunc(songs, position.key(), func(song librarySong, key libraryKey) int {
+			return compareKeys(order, song.key, key)
+		})
+		if position.Backward {
+			end = boundary
+		} else if found {
+			start = boundary + 1
+		} else {
+			start = boundary
+		}
+	}
+
+	has_more := end-start > int(page_size)
+	if position.Backward {
+		start = max(start, end-int(page_size))
+	} else {
+		end = min(end, start+int(page_size))
+	}
+	result := cursorPage(libraryEntries(songs[start:end]), order, position, has_cursor, has_more)
+	if with_count {
+		count := int64(len(songs))
+		result.Count = &count
+	}
+	return result, nil
+}
+
+// ExportSongs calls emit for every song matching the filter, ordered like GetFiltered.
+// The matching songs are copied first, so emit runs without holding the lock.
+func (db *MemoryDb) ExportSongs(ctx context.Context, filter LibraryFilter, emit func(Song) error) error {
+	db.log(ctx).Info("exporting library, groups ", filter.Groups, " song '", filter.Song, "'")
+	songs := db.filterLibrary(filter, filter.order())
+	for _, current := range songs {
+		if err := ctx.Err(); err != nil {
+			db.log(ctx).Error("export interrupted: ", err.Error())
+			return err
+		}
+		if err := emit(current.song); err != nil {
+			db.log(ctx).Error("export interrupted: ", err.Error())
+			return err
+		}
+	}
+	db.log(ctx).Info("exported ", len(songs), " songs")
+	return nil
+}
+
+func (db *MemoryDb) UpdateSong(ctx context.Context, song LibraryEntry, new_group, new_name, new_text, new_url string, new_release_date *time.Time, new_artists []Artist, author string) error {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of UpdateSong: group and/or song name is empty")
+		return ErrInvalidData
+	} else if err := ValidateArtists(new_artists); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+	details_changed := new_group != "" || new_name != "" || new_text != "" || new_url != "" || new_release_date != nil
+	if !details_changed && new_artists == nil {
+		// nothing to update
+		db.log(ctx).Debug("empty update: group '", song.Group, "', song '", song.Song, "'")
+		return nil
+	}
+
+	db.log(ctx).Info("updating song '", song.Song, "', group '", song.Group, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := songKey{group: song.Group, name: song.Song}
+	data, exists := db.songs[key]
+	if !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return ErrSongNotFound
+	}
+
+	new_key := key
+	if new_group != "" {
+		new_key.group = new_group
+	}
+	if new_name != "" {
+		new_key.name = new_name
+	}
+	if new_key != key {
+		if _, exists := db.songs[new_key]; exists {
+			db.log(ctx).Error(ErrSongExists.Error())
+			return ErrSongExists
+		}
+	}
+
+	updated := *data
+	if new_text != "" {
+		updated.text = new_text
+	}
+	if new_url != "" {
+		updated.url = new_url
+	}
+	if new_release_date != nil {
+		updated.release_date = *new_release_date
+		// the song's own release date replaces the one inherited from its album
+		if updated.track != nil {
+			track := *updated.track
+			track.inherit_release_date = false
+			updated.track = &track
+		}
+	}
+
+	// albums only have songs of their group as tracks
+	if new_key.group != key.group {
+		updated.track = nil
+	}
+
+	if new_artists != nil {
+		updated.artists = slices.Clone(new_artists)
+		db.addArtists(new_artists)
+	}
+
+	if details_changed {
+		previous := data.state(key)
+		updated.addRevision(new_key, author, &previous)
+	}
+
+	db.groups[new_key.group] = struct{}{}
+	delete(db.songs, key)
+	db.songs[new_key] = &updated
+	db.log(ctx).Info("update successful")
+	return nil
+}
+
+func (db *MemoryDb) GetRevisions(ctx context.Context, song LibraryEntry) ([]Revision, error) {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of GetRevisions: group and/or song name is empty")
+		return nil, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving revisions of song '", song.Song, "', group '", song.Group, "'")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	data, exists := db.songs[songKey{group: song.Group, name: song.Song}]
+	if !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return nil, ErrSongNotFound
+	}
+	return slices.Clone(data.revisions), nil
+}
+
+func (db *MemoryDb) GetRevision(ctx context.Context, song LibraryEntry, number int) (Revision, error) {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of GetRevision: group and/or song name is empty")
+		return Revision{}, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving revision ", number, " of song '", song.Song, "', group '", song.Group, "'")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.getRevision(ctx, song, number)
+}
+
+func (db *MemoryDb) getRevision(ctx context.Context, song LibraryEntry, number int) (Revision, error) {
+	data, exists := db.songs[songKey{group: song.Group, name: song.Song}]
+	if !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return Revision{}, ErrSongNotFound
+	} else if number < 1 || number > len(data.revisions) {
+		db.log(ctx).Error(ErrRevisionNotFound.Error())
+		return Revision{}, ErrRevisionNotFound
+	}
+	return data.revisions[number-1], nil
+}
+
+func (db *MemoryDb) RestoreRevision(ctx context.Context, song LibraryEntry, number int, author string) (Revision, error) {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of RestoreRevision: group and/or song name is empty")
+		return Revision{}, ErrInvalidData
+	}
+	db.log(ctx).Info("restoring revision ", number, " of song '", song.Song, "', group '", song.Group, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	revision, err := db.getRevision(ctx, song, number)
+	if err != nil {
+		return Revision{}, err
+	}
+	restored := revision.Current
+	date, err := time.Parse(DateFmt, restored.ReleaseDate)
+	if err != nil {
+		db.log(ctx).Error("failed to parse release date: ", err.Error())
+		return Revision{}, err
+	}
+
+	key := songKey{group: song.Group, name: song.Song}
+	new_key := songKey{group: restored.Group, name: restored.Song}
+	if _, exists := db.songs[new_key]; exists && new_key != key {
+		db.log(ctx).Error(ErrSongExists.Error())
+		return Revision{}, ErrSongExists
+	}
+	data := db.songs[key]
+	previous := data.state(key)
+	updated := *data
+	updated.text, updated.url, updated.release_date = restored.Text, restored.URL, date
+	// the restored release date replaces the one inherited from its album
+	if updated.track != nil && restored.ReleaseDate != previous.ReleaseDate {
+		track := *updated.track
+		track.inherit_release_date = false
+		updated.track = &track
+	}
+	// albums only have songs of their group as tracks
+	if new_key.group != key.group {
+		updated.track = nil
+	}
+	updated.addRevision(new_key, author, &previous)
+
+	db.groups[new_key.group] = struct{}{}
+	delete(db.songs, key)
+	db.songs[new_key] = &updated
+	result := updated.revisions[len(updated.revisions)-1]
+	db.log(ctx).Info("revision ", number, " restored as revision ", result.Number)
+	return result, nil
+}
+
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// highlightLine wraps words of the line that are present in terms with <b></b>.
+// It returns false if none of the words match.
+func highlightLine(line string, terms map[string]struct{}) (string, bool) {
+	var builder strings.Builder
+	matched := false
+	word_start := -1
+	flush := func(end int) {
+		word := line[word_start:end]
+		if _, found := terms[strings.ToLower(word)]; found {
+			matched = true
+			builder.WriteString("<b>" + word + "</b>")
+		} else {
+			builder.WriteString(word)
+		}
+		word_start = -1
+	}
+	for idx, r := range line {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if word_start == -1 {
+				word_start = idx
+			}
+			continue
+		}
+		if word_start != -1 {
+			flush(idx)
+		}
+		builder.WriteRune(r)
+	}
+	if word_start != -1 {
+		flush(len(line))
+	}
+	return builder.String(), matched
+}
+
+// Search matches songs containing every word of the query. Songs are ranked by
+// the share of lyrics words that match the query.
+func (db *MemoryDb) Search(ctx context.Context, query string, page_idx, page_size uint) (SearchPage, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		db.log(ctx).Error("invalid use of Search: query is empty")
+		return SearchPage{}, ErrEmptyFilter
+	}
+	term_set := make(map[string]struct{}, len(terms))
+	for _, term := range terms {
+		term_set[term] = struct{}{}
+	}
+
+	db.log(ctx).Info("searching lyrics, query '", query, "', page ", page_idx, ", page size ", page_size)
+	db.mutex.RLock()
+	results := make([]SearchResult, 0)
+	for key, data := range db.songs {
+		words := searchTerms(data.text)
+		found := make(map[string]struct{}, len(term_set))
+		hits := 0
+		for _, word := range words {
+			if _, is_term := term_set[word]; is_term {
+				found[word] = struct{}{}
+				hits++
+			}
+		}
+		if len(found) != len(term_set) {
+			continue
+		}
+
+		snippets := make([]string, 0, 3)
+		for _, line := range strings.Split(data.text, "\n") {
+			if highlighted, matched := highlightLine(line, term_set); matched {
+				snippets = append(snippets, highlighted)
+				if len(snippets) == cap(snippets) {
+					break
+				}
+			}
+		}
+		results = append(results, SearchResult{
+			LibraryEntry: LibraryEntry{Group: key.group, Song: key.name, ReleaseDate: data.release_date.Format(DateFmt)},
+			Rank:         float32(hits) / float32(len(words)),
+			Snippet:      strings.Join(snippets, " ... "),
+		})
+	}
+	db.mutex.RUnlock()
+
+	page_count, err := countPages(int64(len(results)), page_idx, page_size)
+	if err == ErrInvalidData {
+		db.log(ctx).Error("page size must be non-zero")
+		return SearchPage{}, err
+	} else if err != nil {
+		db.log(ctx).Error("page ", page_idx, " out of bounds, max page: ", page_count)
+		return SearchPage{}, err
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if a.Rank != b.Rank {
+			if a.Rank > b.Rank {
+				return -1
+			}
+			return 1
+		} else if cmp := strings.Compare(a.Group, b.Group); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.Song, b.Song)
+	})
+
+	result := SearchPage{PageCount: page_count, PageIndex: page_idx}
+	start := min(int(page_idx*page_size), len(results))
+	end := min(start+int(page_size), len(results))
+	if start != end {
+		result.Entries = results[start:end]
+	}
+	return result, nil
+}
+
+func (db *MemoryDb) AddJob(ctx context.Context, job Job) error {
+	db.log(ctx).Info("adding ingestion job ", job.ID)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	job.UpdatedAt = job.CreatedAt
+	db.jobs[job.ID] = job
+	return nil
+}
+
+func (db *MemoryDb) UpdateJobStatus(ctx context.Context, id string, status JobStatus, reason string) error {
+	db.log(ctx).Info("setting ingestion job ", id, " status to ", status)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	job, exists := db.jobs[id]
+	if !exists {
+		db.log(ctx).Error(ErrJobNotFound.Error())
+		return ErrJobNotFound
+	}
+	job.Status = status
+	job.Error = reason
+	job.UpdatedAt = time.Now()
+	db.jobs[id] = job
+	return nil
+}
+
+func (db *MemoryDb) GetJob(ctx context.Context, id string) (Job, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	job, exists := db.jobs[id]
+	if !exists {
+		db.log(ctx).Error(ErrJobNotFound.Error())
+		return Job{}, ErrJobNotFound
+	}
+	return job, nil
+}
+
+func (db *MemoryDb) GetPendingJobs(ctx context.Context) ([]Job, error) {
+	db.mutex.RLock()
+	var result []Job
+	for _, job := range db.jobs {
+		if job.Status == JobQueued || job.Status == JobRunning {
+			result = append(result, job)
+		}
+	}
+	db.mutex.RUnlock()
+	slices.SortFunc(result, func(a, b Job) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return result, nil
+}
+
+func (db *MemoryDb) GetTrash(ctx context.Context, page_idx, page_size uint) (TrashPage, error) {
+	db.log(ctx).Info("retrieving trash, page ", page_idx, ", page size ", page_size)
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	page_count, err := countPages(int64(len(db.trash)), page_idx, page_size)
+	if err == ErrInvalidData {
+		db.log(ctx).Error("page size must be non-zero")
+		return TrashPage{}, err
+	} else if err != nil {
+		db.log(ctx).Error("page ", page_idx, " out of bounds, max page: ", page_count)
+		return TrashPage{}, err
+	}
+
+	result := TrashPage{PageCount: page_count, PageIndex: page_idx, Entries: make([]TrashEntry, 0)}
+	start := min(int(page_idx*page_size), len(db.trash))
+	end := min(start+int(page_size), len(db.trash))
+	for i := start; i < end; i++ {
+		// most recently deleted first
+		entry := db.trash[len(db.trash)-1-i]
+		result.Entries = append(result.Entries, TrashEntry{
+			LibraryEntry: LibraryEntry{Group: entry.key.group, Song: entry.key.name, ReleaseDate: entry.song.release_date.Format(DateFmt)},
+			DeletedAt:    entry.deleted_at,
+		})
+	}
+	return result, nil
+}
+
+func (db *MemoryDb) RestoreSong(ctx context.Context, song LibraryEntry) error {
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of RestoreSong: one of the parameters is empty")
+		return ErrInvalidData
+	}
+
+	db.log(ctx).Info("restoring song, group: '", song.Group, "', song: '", song.Song, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := songKey{group: song.Group, name: song.Song}
+	for i := len(db.trash) - 1; i >= 0; i-- {
+		if db.trash[i].key != key {
+			continue
+		}
+		if _, exists := db.songs[key]; exists {
+			db.log(ctx).Error(ErrSongExists.Error())
+			return ErrSongExists
+		}
+		db.songs[key] = db.trash[i].song
+		db.trash = slices.Delete(db.trash, i, i+1)
+		db.log(ctx).Info("song restored")
+		return nil
+	}
+	db.log(ctx).Error(ErrSongNotFound.Error())
+	return ErrSongNotFound
+}
+
+func (db *MemoryDb) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	db.log(ctx).Info("purging songs deleted before ", before.Format(time.RFC3339))
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	count := len(db.trash)
+	db.trash = slices.DeleteFunc(db.trash, func(entry memoryTrashEntry) bool {
+		return entry.deleted_at.Before(before)
+	})
+	purged := int64(count - len(db.trash))
+	db.log(ctx).Info("purged ", purged, " songs")
+	return purged, nil
+}
+
+func (db *MemoryDb) AddKey(ctx context.Context, key APIKey, hash string) error {
+	db.log(ctx).Info("adding API key ", key.ID, " for '", key.Name, "', role ", key.Role)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.keys[hash] = key
+	return nil
+}
+
+func (db *MemoryDb) GetKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	key, exists := db.keys[hash]
+	if !exists || key.RevokedAt != nil {
+		db.log(ctx).Error(ErrKeyNotFound.Error())
+		return APIKey{}, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (db *MemoryDb) GetKeys(ctx context.Context) ([]APIKey, error) {
+	db.mutex.RLock()
+	result := make([]APIKey, 0, len(db.keys))
+	for _, key := range db.keys {
+		result = append(result, key)
+	}
+	db.mutex.RUnlock()
+	slices.SortFunc(result, func(a, b APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return result, nil
+}
+
+func (db *MemoryDb) RevokeKey(ctx context.Context, id string) error {
+	db.log(ctx).Info("revoking API key ", id)
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	for hash, key := range db.keys {
+		if key.ID == id && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			db.keys[hash] = key
+			return nil
+		}
+	}
+	db.log(ctx).Error(ErrKeyNotFound.Error())
+	return ErrKeyNotFound
+}
+
+// addArtists registers the artists as groups, like Db stores them.
+func (db *MemoryDb) addArtists(artists []Artist) {
+	for _, artist := range artists {
+		db.groups[artist.Name] = struct{}{}
+	}
+}
+
+// forEachSong calls f for every song including the deleted ones. The write lock must be held.
+func (db *MemoryDb) forEachSong(f func(key songKey, song *memorySong)) {
+	for key, song := range db.songs {
+		f(key, song)
+	}
+	for _, entry := range db.trash {
+		f(entry.key, entry.song)
+	}
+}
+
+// setTrack attaches the song, which must exist, to the album. The write lock must be held.
+func (db *MemoryDb) setTrack(album_key albumKey, album *memoryAlbum, track Track, author string) {
+	db.forEachSong(func(_ songKey, song *memorySong) {
+		if song.track != nil && song.track.album == album_key && song.track.number == track.Number {
+			song.track = nil
+		}
+	})
+	key := songKey{group: album_key.group, name: track.Song}
+	data := db.songs[key]
+	updated := *data
+	updated.track = &memoryTrack{album: album_key, number: track.Number, inherit_release_date: track.InheritReleaseDate}
+	if track.InheritReleaseDate && !updated.release_date.Equal(album.release_date) {
+		previous := data.state(key)
+		updated.release_date = album.release_date
+		updated.addRevision(key, author, &previous)
+	}
+	db.songs[key] = &updated
+}
+
+func (db *MemoryDb) AddAlbum(ctx context.Context, group, title string, date time.Time, cover_url string, tracks []Track, author string) error {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of AddAlbum: group and/or title is empty")
+		return ErrInvalidData
+	} else if err := ValidateTracks(tracks); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+
+	db.log(ctx).Info("adding album '", title, "', group '", group, "', ", len(tracks), " tracks")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := albumKey{group: group, title: title}
+	if _, exists := db.albums[key]; exists {
+		db.log(ctx).Error(ErrAlbumExists.Error())
+		return ErrAlbumExists
+	}
+	// nothing is changed unless all songs exist
+	for _, track := range tracks {
+		if _, exists := db.songs[songKey{group: group, name: track.Song}]; !exists {
+			db.log(ctx).Error(ErrSongNotFound.Error())
+			return ErrSongNotFound
+		}
+	}
+	album := &memoryAlbum{release_date: date, cover_url: cover_url}
+	db.groups[group] = struct{}{}
+	db.albums[key] = album
+	for _, track := range tracks {
+		db.setTrack(key, album, track, author)
+	}
+	db.log(ctx).Info("album successfully added")
+	return nil
+}
+
+func (db *MemoryDb) GetAlbum(ctx context.Context, group, title string) (Album, error) {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of GetAlbum: group and/or title is empty")
+		return Album{}, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving album '", title, "', group '", group, "'")
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	key := albumKey{group: group, title: title}
+	album, exists := db.albums[key]
+	if !exists {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return Album{}, ErrAlbumNotFound
+	}
+	result := Album{Tracks: make([]Track, 0)}
+	result.Group, result.Title, result.ReleaseDate, result.CoverURL = group, title, album.release_date.Format(DateFmt), album.cover_url
+	for song_key, song := range db.songs {
+		if song.track != nil && song.track.album == key {
+			result.Tracks = append(result.Tracks, Track{
+				Number:             song.track.number,
+				Song:               song_key.name,
+				ReleaseDate:        song.release_date.Format(DateFmt),
+				InheritReleaseDate: song.track.inherit_release_date,
+			})
+		}
+	}
+	slices.SortFunc(result.Tracks, func(a, b Track) int {
+		return a.Number - b.Number
+	})
+	result.TrackCount = len(result.Tracks)
+	return result, nil
+}
+
+func (db *MemoryDb) GetAlbums(ctx context.Context, group string, page_idx, page_size uint) (AlbumPage, error) {
+	db.log(ctx).Info("retrieving albums, group '", group, "', page ", page_idx, ", page size ", page_size)
+	db.mutex.RLock()
+	albums := make([]AlbumSummary, 0)
+	indices := make(map[albumKey]int)
+	for key, album := range db.albums {
+		if group == "" || key.group == group {
+			indices[key] = len(albums)
+			albums = append(albums, AlbumSummary{Group: key.group, Title: key.title,
+				ReleaseDate: album.release_date.Format(DateFmt), CoverURL: album.cover_url})
+		}
+	}
+	for _, song := range db.songs {
+		if song.track == nil {
+			continue
+		}
+		if idx, found := indices[song.track.album]; found {
+			albums[idx].TrackCount++
+		}
+	}
+	db.mutex.RUnlock()
+
+	page_count, err := countPages(int64(len(albums)), page_idx, page_size)
+	if err == ErrInvalidData {
+		db.log(ctx).Error("page size must be non-zero")
+		return AlbumPage{}, err
+	} else if err != nil {
+		db.log(ctx).Error("page ", page_idx, " out of bounds, max page: ", page_count)
+		return AlbumPage{}, err
+	}
+
+	slices.SortFunc(albums, func(a, b AlbumSummary) int {
+		if cmp := strings.Compare(a.Group, b.Group); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.Title, b.Title)
+	})
+	start := min(int(page_idx*page_size), len(albums))
+	end := min(start+int(page_size), len(albums))
+	return AlbumPage{PageCount: page_count, PageIndex: page_idx, Entries: albums[start:end]}, nil
+}
+
+func (db *MemoryDb) UpdateAlbum(ctx context.Context, group, title, new_title, new_cover_url string, new_release_date *time.Time, author string) error {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of UpdateAlbum: group and/or title is empty")
+		return ErrInvalidData
+	} else if new_title == "" && new_cover_url == "" && new_release_date == nil {
+		// nothing to update
+		db.log(ctx).Debug("empty update: group '", group, "', album '", title, "'")
+		return nil
+	}
+
+	db.log(ctx).Info("updating album '", title, "', group '", group, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := albumKey{group: group, title: title}
+	album, exists := db.albums[key]
+	if !exists {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return ErrAlbumNotFound
+	}
+	new_key := key
+	if new_title != "" {
+		new_key.title = new_title
+	}
+	if _, exists := db.albums[new_key]; exists && new_key != key {
+		db.log(ctx).Error(ErrAlbumExists.Error())
+		return ErrAlbumExists
+	}
+
+	updated := *album
+	if new_cover_url != "" {
+		updated.cover_url = new_cover_url
+	}
+	if new_release_date != nil {
+		updated.release_date = *new_release_date
+	}
+	delete(db.albums, key)
+	db.albums[new_key] = &updated
+
+	db.forEachSong(func(_ songKey, song *memorySong) {
+		if song.track != nil && song.track.album == key {
+			track := *song.track
+			track.album = new_key
+			song.track = &track
+		}
+	})
+	if new_release_date != nil {
+		for song_key, song := range db.songs {
+			if song.track == nil || song.track.album != new_key || !song.track.inherit_release_date ||
+				song.release_date.Equal(updated.release_date) {
+				continue
+			}
+			previous := song.state(song_key)
+			changed := *song
+			changed.release_date = updated.release_date
+			changed.addRevision(song_key, author, &previous)
+			db.songs[song_key] = &changed
+		}
+	}
+	db.log(ctx).Info("update successful")
+	return nil
+}
+
+func (db *MemoryDb) DeleteAlbum(ctx context.Context, group, title string) error {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of DeleteAlbum: group and/or title is empty")
+		return ErrInvalidData
+	}
+	db.log(ctx).Info("deleting album '", title, "', group '", group, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := albumKey{group: group, title: title}
+	if _, exists := db.albums[key]; !exists {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return ErrAlbumNotFound
+	}
+	delete(db.albums, key)
+	db.forEachSong(func(_ songKey, song *memorySong) {
+		if song.track != nil && song.track.album == key {
+			song.track = nil
+		}
+	})
+	db.log(ctx).Info("deletion successful")
+	return nil
+}
+
+func (db *MemoryDb) SetTrack(ctx context.Context, group, title string, track Track, author string) error {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of SetTrack: group and/or title is empty")
+		return ErrInvalidData
+	} else if err := ValidateTracks([]Track{track}); err != nil {
+		db.log(ctx).Error(err.Error())
+		return err
+	}
+
+	db.log(ctx).Info("setting track ", track.Number, " of album '", title, "', group '", group, "' to '", track.Song, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := albumKey{group: group, title: title}
+	album, exists := db.albums[key]
+	if !exists {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return ErrAlbumNotFound
+	} else if _, exists := db.songs[songKey{group: group, name: track.Song}]; !exists {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return ErrSongNotFound
+	}
+	db.setTrack(key, album, track, author)
+	db.log(ctx).Info("track set")
+	return nil
+}
+
+func (db *MemoryDb) RemoveTrack(ctx context.Context, group, title string, number int) error {
+	if group == "" || title == "" {
+		db.log(ctx).Error("invalid use of RemoveTrack: group and/or title is empty")
+		return ErrInvalidData
+	}
+	db.log(ctx).Info("removing track ", number, " of album '", title, "', group '", group, "'")
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	key := albumKey{group: group, title: title}
+	if _, exists := db.albums[key]; !exists {
+		db.log(ctx).Error(ErrAlbumNotFound.Error())
+		return ErrAlbumNotFound
+	}
+	removed := false
+	db.forEachSong(func(_ songKey, song *memorySong) {
+		if song.track != nil && song.track.album == key && song.track.number == number {
+			song.track = nil
+			removed = true
+		}
+	})
+	if !removed {
+		db.log(ctx).Error(ErrTrackNotFound.Error())
+		return ErrTrackNotFound
+	}
+	db.log(ctx).Info("track removed")
+	return nil
+}
+
+// Ready always succeeds: the in-memory storage is available as long as the process is.
+func (db *MemoryDb) Ready(ctx context.Context) error {
+	return nil
+}
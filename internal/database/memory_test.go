@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+func newTestMemoryDb(t *testing.T) *MemoryDb {
+	t.Helper()
+	log, err := logger.NewLogger(io.Discard, logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMemoryDb(log)
+}
+
+func albumTracks(t *testing.T, db *MemoryDb, group, title string) []Track {
+	t.Helper()
+	album, err := db.GetAlbum(context.Background(), group, title)
+	if err != nil {
+		t.Fatalf("GetAlbum: %v", err)
+	}
+	return album.Tracks
+}
+
+func TestGroupChangeLeavesAlbum(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// move changes the group of the song A of Muse, which is track 1 of the album Origin
+		move func(db *MemoryDb) error
+	}{
+		{
+			name: "update",
+			move: func(db *MemoryDb) error {
+				return db.UpdateSong(ctx, LibraryEntry{Group: "Muse", Song: "A"}, "Queen", "", "", "", nil, nil, "test")
+			},
+		},
+		{
+			name: "restore",
+			move: func(db *MemoryDb) error {
+				// the first revision of A is a song of Queen
+				_, err := db.RestoreRevision(ctx, LibraryEntry{Group: "Muse", Song: "A"}, 1, "test")
+				return err
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestMemoryDb(t)
+			if err := db.AddSong(ctx, "Queen", "A", "text", "url", date, nil, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.UpdateSong(ctx, LibraryEntry{Group: "Queen", Song: "A"}, "Muse", "", "", "", nil, nil, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.AddAlbum(ctx, "Muse", "Origin", date, "", []Track{{Number: 1, Song: "A"}}, "test"); err != nil {
+				t.Fatal(err)
+			}
+			if tracks := albumTracks(t, db, "Muse", "Origin"); len(tracks) != 1 {
+				t.Fatalf("tracks = %+v, want one track", tracks)
+			}
+
+			if err := test.move(db); err != nil {
+				t.Fatalf("moving the song: %v", err)
+			}
+			if tracks := albumTracks(t, db, "Muse", "Origin"); len(tracks) != 0 {
+				t.Errorf("tracks = %+v, want none", tracks)
+			}
+			// the song can be added to an album of its new group
+			if err := db.AddAlbum(ctx, "Queen", "Innuendo", date, "", []Track{{Number: 1, Song: "A"}}, "test"); err != nil {
+				t.Fatalf("AddAlbum: %v", err)
+			}
+			if tracks := albumTracks(t, db, "Queen", "Innuendo"); len(tracks) != 1 || tracks[0].Song != "A" {
+				t.Errorf("tracks = %+v", tracks)
+			}
+		})
+	}
+}
+
+func TestRenameKeepsAlbumTrack(t *testing.T) {
+	ctx := context.Background()
+	date := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := newTestMemoryDb(t)
+	if err := db.AddSong(ctx, "Muse", "A", "text", "url", date, nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddAlbum(ctx, "Muse", "Origin", date, "", []Track{{Number: 1, Song: "A"}}, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateSong(ctx, LibraryEntry{Group: "Muse", Song: "A"}, "Muse", "B", "", "", nil, nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if tracks := albumTracks(t, db, "Muse", "Origin"); len(tracks) != 1 || tracks[0].Song != "B" {
+		t.Errorf("tracks = %+v, want track B", tracks)
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// SongRepository is the storage used by the HTTP layer.
+// Implementations must be safe for concurrent use. All methods log through the logger
+// stored in the context by logger.NewContext, if any, so that log lines carry the request attributes.
+// Operations are interrupted when the context is done: Db returns ErrTimeout if its query timeout
+// or the context deadline expired and ErrCanceled if the context was canceled.
+type SongRepository interface {
+	// AddSong credits the group as the primary artist of the song together with the artists
+	AddSong(ctx context.Context, group string, name string, text string, url string, date time.Time, artists []Artist, author string) error
+	AddSongs(ctx context.Context, songs []Song) ([]error, error)
+	GetSongText(ctx context.Context, group string, song string) (string, error)
+	GetSong(ctx context.Context, group string, song string) (Song, error)
+	DeleteSong(ctx context.Context, song LibraryEntry) error
+	GetFiltered(ctx context.Context, filter LibraryFilter, page_idx, page_size uint) (LibraryPage, error)
+	// GetFilteredByCursor returns the page after or before the cursor of a previous page,
+	// the first page if the cursor is empty. The songs are counted only if with_count is set.
+	// A cursor is only valid with the sort order of the page it was returned with.
+	GetFilteredByCursor(ctx context.Context, filter LibraryFilter, cursor string, page_size uint, with_count bool) (LibraryPage, error)
+	// UpdateSong keeps the credits if new_artists is nil and replaces them with the group
+	// and new_artists otherwise. Changing only the credits doesn't record a revision.
+	UpdateSong(ctx context.Context, song LibraryEntry, new_group, new_name, new_text, new_url string, new_release_date *time.Time, new_artists []Artist, author string) error
+	Search(ctx context.Context, query string, page_idx, page_size uint) (SearchPage, error)
+	ExportSongs(ctx context.Context, filter LibraryFilter, emit func(Song) error) error
+	GetRevisions(ctx context.Context, song LibraryEntry) ([]Revision, error)
+	GetRevision(ctx context.Context, song LibraryEntry, number int) (Revision, error)
+	RestoreRevision(ctx context.Context, song LibraryEntry, number int, author string) (Revision, error)
+	TrashRepository
+	AlbumRepository
+	HealthRepository
+}
+
+// HealthRepository reports whether the storage can serve requests.
+type HealthRepository interface {
+	Ready(ctx context.Context) error
+}
+
+// TrashRepository manages deleted songs. DeleteSong moves songs to the trash,
+// they are removed permanently by PurgeTrash.
+type TrashRepository interface {
+	GetTrash(ctx context.Context, page_idx, page_size uint) (TrashPage, error)
+	RestoreSong(ctx context.Context, song LibraryEntry) error
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AlbumRepository manages albums of a group and their track lists. A song is a track of at most
+// one album and can only be attached to an album of its group. Deleting an album keeps its songs.
+// author is recorded in the revisions of songs whose release date changes with the album.
+type AlbumRepository interface {
+	AddAlbum(ctx context.Context, group, title string, date time.Time, cover_url string, tracks []Track, author string) error
+	GetAlbum(ctx context.Context, group, title string) (Album, error)
+	// GetAlbums lists albums of the group, or of all groups if group is empty, without the tracks
+	GetAlbums(ctx context.Context, group string, page_idx, page_size uint) (AlbumPage, error)
+	UpdateAlbum(ctx context.Context, group, title, new_title, new_cover_url string, new_release_date *time.Time, author string) error
+	DeleteAlbum(ctx context.Context, group, title string) error
+	// SetTrack attaches the song as the track with the given number, replacing the song
+	// previously at that number and detaching the song from its previous album
+	SetTrack(ctx context.Context, group, title string, track Track, author string) error
+	RemoveTrack(ctx context.Context, group, title string, number int) error
+}
+
+// JobRepository persists ingestion jobs so that they survive restarts.
+type JobRepository interface {
+	AddJob(ctx context.Context, job Job) error
+	UpdateJobStatus(ctx context.Context, id string, status JobStatus, reason string) error
+	GetJob(ctx context.Context, id string) (Job, error)
+	GetPendingJobs(ctx context.Context) ([]Job, error)
+}
+
+// KeyRepository stores API keys by the hash of the key.
+type KeyRepository interface {
+	AddKey(ctx context.Context, key APIKey, hash string) error
+	GetKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	GetKeys(ctx context.Context) ([]APIKey, error)
+	RevokeKey(ctx context.Context, id string) error
+}
+
+var (
+	_ KeyRepository  = (*Db)(nil)
+	_ KeyRepository  = (*MemoryDb)(nil)
+	_ SongRepository = (*Db)(nil)
+	_ SongRepository = (*MemoryDb)(nil)
+	_ JobRepository  = (*Db)(nil)
+	_ JobRepository  = (*MemoryDb)(nil)
+)
+
+func countPages(count int64, page_idx, page_size uint) (uint, error) {
+	if page_size == 0 {
+		return 0, ErrInvalidData
+	}
+	page_count := count / int64(page_size)
+	if count%int64(page_size) != 0 {
+		page_count++
+	}
+	if page_idx != 0 && int64(page_idx) >= page_count {
+		return uint(page_count), ErrPageOutOfBounds
+	}
+	return uint(page_count), nil
+}
@@ -0,0 +1,365 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Revision is a recorded change of a song. The first revision of a song records its creation
+// and has no previous state.
+type Revision struct {
+	Number    int       `json:"revision"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Previous  *Song     `json:"previous,omitempty"`
+	Current   Song      `json:"current"`
+}
+
+type LineOp string
+
+const (
+	LineKept    LineOp = "="
+	LineRemoved LineOp = "-"
+	LineAdded   LineOp = "+"
+)
+
+type LineDiff struct {
+	Op   LineOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// FieldDiff describes a changed field. Lyrics are compared line by line and reported in Lines.
+type FieldDiff struct {
+	Field string     `json:"field"`
+	From  string     `json:"from,omitempty"`
+	To    string     `json:"to,omitempty"`
+	Lines []LineDiff `json:"lines,omitempty"`
+}
+
+type RevisionDiff struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Changes []FieldDiff `json:"changes"`
+}
+
+// lines above which the lyrics diff is not computed line by line
+const maxDiffLines = 2000
+
+// DiffRevisions compares the song states recorded by two revisions.
+func DiffRevisions(from, to Revision) RevisionDiff {
+	result := RevisionDiff{From: from.Number, To: to.Number, Changes: make([]FieldDiff, 0)}
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"group", from.Current.Group, to.Current.Group},
+		{"song", from.Current.Song, to.Current.Song},
+		{"url", from.Current.URL, to.Current.URL},
+		{"release_date", from.Current.ReleaseDate, to.Current.ReleaseDate},
+	}
+	for _, field := range fields {
+		if field.from != field.to {
+			result.Changes = append(result.Changes, FieldDiff{Field: field.name, From: field.from, To: field.to})
+		}
+	}
+	if from.Current.Text != to.Current.Text {
+		result.Changes = append(result.Changes, FieldDiff{Field: "text", Lines: diffLines(from.Current.Text, to.Current.Text)})
+	}
+	return result
+}
+
+// diffLines returns a line diff based on the longest common subsequence of lines.
+func diffLines(from, to string) []LineDiff {
+	a, b := strings.Split(from, "\n"), strings.Split(to, "\n")
+	result := make([]LineDiff, 0, len(a)+len(b))
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		for _, line := range a {
+			result = append(result, LineDiff{Op: LineRemoved, Text: line})
+		}
+		for _, line := range b {
+			result = append(result, LineDiff{Op: LineAdded, Text: line})
+		}
+		return result
+	}
+
+	// common[i][j] is the LCS length of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			result = append(result, LineDiff{Op: LineKept, Text: a[i]})
+			i++
+			j++
+		} else if common[i+1][j] >= common[i][j+1] {
+			result = append(result, LineDiff{Op: LineRemoved, Text: a[i]})
+			i++
+		} else {
+			result = append(result, LineDiff{Op: LineAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, LineDiff{Op: LineRemoved, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, LineDiff{Op: LineAdded, Text: b[j]})
+	}
+	return result
+}
+
+// addRevision records the change of the song from previous (nil for a new song) to current.
+func (db *Db) addRevision(ctx context.Context, transaction pgx.Tx, song_id int64, author string, previous *Song, current Song) error {
+	var prev_group, prev_name, prev_text, prev_url *string
+	var prev_date *time.Time
+	if previous != nil {
+		date, err := time.Parse(DateFmt, previous.ReleaseDate)
+		if err != nil {
+			db.log(ctx).Error("failed to parse previous release date: ", err.Error())
+			return err
+		}
+		prev_group, prev_name, prev_text, prev_url, prev_date = &previous.Group, &previous.Song, &previous.Text, &previous.URL, &date
+	}
+	date, err := time.Parse(DateFmt, current.ReleaseDate)
+	if err != nil {
+		db.log(ctx).Error("failed to parse release date: ", err.Error())
+		return err
+	}
+	_, err = transaction.Exec(ctx, addRevisionQuery, song_id, author,
+		prev_group, prev_name, prev_text, prev_url, prev_date,
+		current.Group, current.Song, current.Text, current.URL, date)
+	if err != nil {
+		db.log(ctx).Error("failed to add song revision: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func scanRevision(row pgx.Row) (Revision, error) {
+	revision := Revision{}
+	var prev_group, prev_name, prev_text, prev_url *string
+	var prev_date *time.Time
+	var date time.Time
+	err := row.Scan(&revision.Number, &revision.Author, &revision.CreatedAt,
+		&prev_group, &prev_name, &prev_text, &prev_url, &prev_date,
+		&revision.Current.Group, &revision.Current.Song, &revision.Current.Text, &revision.Current.URL, &date)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision.Current.ReleaseDate = date.Format(DateFmt)
+	if prev_group != nil {
+		revision.Previous = &Song{Group: *prev_group, Song: *prev_name, Text: *prev_text, URL: *prev_url,
+			ReleaseDate: prev_date.Format(DateFmt)}
+	}
+	return revision, nil
+}
+
+// getSongState locks the song for the rest of the transaction and returns its id and details.
+func (db *Db) getSongState(ctx context.Context, transaction pgx.Tx, group, song string) (int64, Song, error) {
+	var song_id int64
+	var date time.Time
+	state := Song{}
+	err := transaction.QueryRow(ctx, getSongStateQuery, group, song).
+		Scan(&song_id, &state.Group, &state.Song, &state.Text, &state.URL, &date)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return 0, Song{}, ErrSongNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song: ", err.Error())
+		return 0, Song{}, err
+	}
+	state.ReleaseDate = date.Format(DateFmt)
+	return song_id, state, nil
+}
+
+// GetRevisions returns the revisions of the song, oldest first.
+func (db *Db) GetRevisions(ctx context.Context, song LibraryEntry) (_ []Revision, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of GetRevisions: group and/or song name is empty")
+		return nil, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving revisions of song '", song.Song, "', group '", song.Group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return nil, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	var song_id int64
+	err = transaction.QueryRow(ctx, getSongIdQuery, song.Song, song.Group).Scan(&song_id)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return nil, ErrSongNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song id: ", err.Error())
+		return nil, err
+	}
+
+	rows, err := transaction.Query(ctx, getRevisionsQuery, song_id)
+	if err != nil {
+		db.log(ctx).Error("failed to get song revisions: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]Revision, 0)
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			db.log(ctx).Error("failed to read song revision: ", err.Error())
+			return nil, err
+		}
+		result = append(result, revision)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to get song revisions: ", err.Error())
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *Db) GetRevision(ctx context.Context, song LibraryEntry, number int) (_ Revision, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of GetRevision: group and/or song name is empty")
+		return Revision{}, ErrInvalidData
+	}
+	db.log(ctx).Info("retrieving revision ", number, " of song '", song.Song, "', group '", song.Group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return Revision{}, err
+	}
+	defer transaction.Rollback(context.Background())
+	return db.getRevision(ctx, transaction, song, number)
+}
+
+func (db *Db) getRevision(ctx context.Context, transaction pgx.Tx, song LibraryEntry, number int) (Revision, error) {
+	var song_id int64
+	err := transaction.QueryRow(ctx, getSongIdQuery, song.Song, song.Group).Scan(&song_id)
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return Revision{}, ErrSongNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song id: ", err.Error())
+		return Revision{}, err
+	}
+
+	revision, err := scanRevision(transaction.QueryRow(ctx, getRevisionQuery, song_id, number))
+	if err == pgx.ErrNoRows {
+		db.log(ctx).Error(ErrRevisionNotFound.Error())
+		return Revision{}, ErrRevisionNotFound
+	} else if err != nil {
+		db.log(ctx).Error("failed to get song revision: ", err.Error())
+		return Revision{}, err
+	}
+	return revision, nil
+}
+
+// RestoreRevision sets the song details to the state recorded by the revision.
+// The restoration is recorded as a new revision, which is returned.
+func (db *Db) RestoreRevision(ctx context.Context, song LibraryEntry, number int, author string) (_ Revision, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of RestoreRevision: group and/or song name is empty")
+		return Revision{}, ErrInvalidData
+	}
+	db.log(ctx).Info("restoring revision ", number, " of song '", song.Song, "', group '", song.Group, "'")
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return Revision{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	song_id, previous, err := db.getSongState(ctx, transaction, song.Group, song.Song)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision, err := db.getRevision(ctx, transaction, song, number)
+	if err != nil {
+		return Revision{}, err
+	}
+	restored := revision.Current
+	date, err := time.Parse(DateFmt, restored.ReleaseDate)
+	if err != nil {
+		db.log(ctx).Error("failed to parse release date: ", err.Error())
+		return Revision{}, err
+	}
+
+	group_id, err := db.getOrAddGroupID(ctx, restored.Group, transaction)
+	if err != nil {
+		db.log(ctx).Error("failed to get group id: ", err.Error())
+		return Revision{}, err
+	}
+	_, err = transaction.Exec(ctx, restoreSongQuery, song_id, group_id, restored.Song)
+	if isSongExistsError(err) {
+		db.log(ctx).Error(ErrSongExists.Error())
+		return Revision{}, ErrSongExists
+	} else if err != nil {
+		db.log(ctx).Error("failed to restore song: ", err.Error())
+		return Revision{}, err
+	}
+	_, err = transaction.Exec(ctx, restoreSongInfoQuery, song_id, restored.Text, restored.URL, date)
+	if err != nil {
+		db.log(ctx).Error("failed to restore song details: ", err.Error())
+		return Revision{}, err
+	}
+	// the restored release date replaces the one inherited from its album
+	if restored.ReleaseDate != previous.ReleaseDate {
+		if _, err = transaction.Exec(ctx, stopInheritingQuery, song_id); err != nil {
+			db.log(ctx).Error("failed to update album track: ", err.Error())
+			return Revision{}, err
+		}
+	}
+	if restored.Group != previous.Group {
+		if err = db.movePrimaryArtist(ctx, transaction, song_id, previous.Group, group_id); err != nil {
+			return Revision{}, err
+		}
+		// albums only have songs of their group as tracks
+		if _, err = transaction.Exec(ctx, leaveAlbumQuery, song_id); err != nil {
+			db.log(ctx).Error("failed to remove album track: ", err.Error())
+			return Revision{}, err
+		}
+	}
+	if err = db.addRevision(ctx, transaction, song_id, author, &previous, restored); err != nil {
+		return Revision{}, err
+	}
+	result, err := scanRevision(transaction.QueryRow(ctx, getLastRevisionQuery, song_id))
+	if err != nil {
+		db.log(ctx).Error("failed to get song revision: ", err.Error())
+		return Revision{}, err
+	}
+
+	if err = transaction.Commit(ctx); err != nil {
+		db.log(ctx).Error("failed to commit transaction: ", err.Error())
+		return Revision{}, err
+	}
+	db.log(ctx).Info("revision ", number, " restored as revision ", result.Number)
+	return result, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/metrics"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	otherStatement       = "other"
+	transactionStatement = "transaction"
+
+	tracer_name = "github.com/Onlymiind/test_task/internal/database"
+)
+
+// dynamicStatements names queries that are built at runtime and so aren't prepared,
+// such a query can also name itself with a leading /* name */ comment
+var dynamicStatements = []struct {
+	prefix string
+	name   string
+}{
+	{getLibraryFilterBase, "get_filtered"},
+	{getLibraryFilterCountBase, "get_filtered_count"},
+	{exportBase, "export"},
+	{exportFetch, "export"},
+	{exportCursorClose, "export"},
+	{migrationVersionQuery, "migration_version"},
+	{"UPDATE " + updateSongTable + " SET", "update_song"},
+	{"UPDATE " + updateSongInfoTable + " SET", "update_song_info"},
+	{"UPDATE " + updateAlbumTable + " SET", "update_album"},
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	statement string
+	command   string
+	start     time.Time
+	span      trace.Span
+}
+
+// queryTracer records duration and errors of every query by statement name and creates
+// a span for every query and transaction. Queries inside a transaction are children of its span.
+type queryTracer struct {
+	prepared map[string]struct{}
+	tracer   trace.Tracer
+
+	mutex sync.Mutex
+	// contexts of the transaction spans, by the connection running the transaction
+	transactions map[*pgx.Conn]context.Context
+}
+
+func newQueryTracer() *queryTracer {
+	prepared := make(map[string]struct{}, len(preparedQueries))
+	for _, query := range preparedQueries {
+		prepared[query.name] = struct{}{}
+	}
+	return &queryTracer{
+		prepared:     prepared,
+		tracer:       otel.Tracer(tracer_name),
+		transactions: make(map[*pgx.Conn]context.Context),
+	}
+}
+
+// statementName returns a bounded label for the query, raw SQL is never used as a label.
+// command is the lower case transaction control command, if the query is one.
+func (t *queryTracer) statementName(sql string) (statement string, command string) {
+	if _, found := t.prepared[sql]; found {
+		return sql, ""
+	}
+	if comment, found := strings.CutPrefix(sql, "/* "); found {
+		if name, _, found := strings.Cut(comment, " */"); found {
+			return name, ""
+		}
+	}
+	for _, dynamic := range dynamicStatements {
+		if strings.HasPrefix(sql, dynamic.prefix) {
+			return dynamic.name, ""
+		}
+	}
+	words := strings.Fields(strings.ToLower(sql))
+	if len(words) == 0 {
+		return otherStatement, ""
+	}
+	switch words[0] {
+	case "rollback":
+		if len(words) > 1 && words[1] == "to" {
+			// rollback of a savepoint doesn't end the transaction
+			return transactionStatement, "savepoint"
+		}
+		return transactionStatement, words[0]
+	case "begin", "commit", "savepoint", "release":
+		return transactionStatement, words[0]
+	}
+	return otherStatement, ""
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement, command := t.statementName(data.SQL)
+
+	t.mutex.Lock()
+	if command == "begin" {
+		transaction_ctx, _ := t.tracer.Start(ctx, transactionStatement, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL))
+		t.transactions[conn] = transaction_ctx
+	}
+	if transaction_ctx, found := t.transactions[conn]; found {
+		ctx = transaction_ctx
+	}
+	t.mutex.Unlock()
+
+	ctx, span := t.tracer.Start(ctx, statement, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, attribute.String("db.statement.name", statement)))
+	return context.WithValue(ctx, queryStartKey{}, queryStart{statement: statement, command: command, start: time.Now(), span: span})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, found := ctx.Value(queryStartKey{}).(queryStart)
+	if !found {
+		return
+	}
+	metrics.ObserveQuery(start.statement, time.Since(start.start), data.Err)
+	if data.Err != nil {
+		start.span.RecordError(data.Err)
+		start.span.SetStatus(codes.Error, data.Err.Error())
+	}
+	start.span.End()
+
+	if start.command == "commit" || start.command == "rollback" {
+		t.endTransaction(conn, start.command, data.Err)
+	}
+}
+
+func (t *queryTracer) endTransaction(conn *pgx.Conn, outcome string, err error) {
+	t.mutex.Lock()
+	transaction_ctx, found := t.transactions[conn]
+	delete(t.transactions, conn)
+	t.mutex.Unlock()
+	if !found {
+		return
+	}
+	span := trace.SpanFromContext(transaction_ctx)
+	span.SetAttributes(attribute.String("db.transaction.outcome", outcome))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// connectionClosed ends the span of a transaction left open on a closed connection.
+func (t *queryTracer) connectionClosed(conn *pgx.Conn) {
+	t.endTransaction(conn, "closed", nil)
+}
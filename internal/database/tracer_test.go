@@ -0,0 +1,29 @@
+package database
+
+import "testing"
+
+func TestStatementName(t *testing.T) {
+	tracer := newQueryTracer()
+	tests := []struct {
+		sql       string
+		statement string
+		command   string
+	}{
+		{getSongQuery, getSongQuery, ""},
+		{getLibraryFilterBase + " LIMIT $1;", "get_filtered", ""},
+		{getLibraryFilterCountBase + ";", "get_filtered_count", ""},
+		{getLibraryCursorBase + " LIMIT $1;", "get_filtered_cursor", ""},
+		{getLibraryCursorCountBase + ";", "get_filtered_cursor_count", ""},
+		{"UPDATE songs SET song_name = $1;", "update_song", ""},
+		{"begin", transactionStatement, "begin"},
+		{"ROLLBACK TO SAVEPOINT s", transactionStatement, "savepoint"},
+		{"SELECT 1", otherStatement, ""},
+		{"/* unterminated SELECT 1", otherStatement, ""},
+	}
+	for _, test := range tests {
+		statement, command := tracer.statementName(test.sql)
+		if statement != test.statement || command != test.command {
+			t.Errorf("statementName(%q) = %q, %q, want %q, %q", test.sql, statement, command, test.statement, test.command)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// TrashEntry is a deleted song that can still be restored.
+type TrashEntry struct {
+	LibraryEntry
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type TrashPage struct {
+	PageIndex uint         `json:"page_idx"`
+	PageCount uint         `json:"page_count"`
+	Entries   []TrashEntry `json:"entries"`
+}
+
+// GetTrash returns deleted songs, most recently deleted first.
+func (db *Db) GetTrash(ctx context.Context, page_idx, page_size uint) (_ TrashPage, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("retrieving trash, page ", page_idx, ", page size ", page_size)
+	transaction, err := db.begin(ctx)
+	if err != nil {
+		db.log(ctx).Error("failed to start transaction: ", err.Error())
+		return TrashPage{}, err
+	}
+	defer transaction.Rollback(context.Background())
+
+	count, err := db.getCount(ctx, transaction, getTrashCountQuery)
+	if err != nil {
+		return TrashPage{}, err
+	}
+	page_count, err := db.validatePageIndex(ctx, count, page_idx, page_size)
+	if err != nil {
+		return TrashPage{}, err
+	}
+
+	rows, err := transaction.Query(ctx, getTrashQuery, page_size, page_idx*page_size)
+	if err != nil {
+		db.log(ctx).Error("failed to retrieve trash: ", err.Error())
+		return TrashPage{}, err
+	}
+	defer rows.Close()
+	result := TrashPage{PageCount: page_count, PageIndex: page_idx, Entries: make([]TrashEntry, 0)}
+	for rows.Next() {
+		entry := TrashEntry{}
+		var date time.Time
+		if err = rows.Scan(&entry.Group, &entry.Song, &date, &entry.DeletedAt); err != nil {
+			db.log(ctx).Error("failed to retrieve trash entry: ", err.Error())
+			return TrashPage{}, err
+		}
+		entry.ReleaseDate = date.Format(DateFmt)
+		result.Entries = append(result.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		db.log(ctx).Error("failed to retrieve trash: ", err.Error())
+		return TrashPage{}, err
+	}
+	return result, nil
+}
+
+// RestoreSong moves the song back from the trash. If it was deleted several times,
+// the most recently deleted copy is restored.
+func (db *Db) RestoreSong(ctx context.Context, song LibraryEntry) (err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	if song.Group == "" || song.Song == "" {
+		db.log(ctx).Error("invalid use of RestoreSong: one of the parameters is empty")
+		return ErrInvalidData
+	}
+
+	db.log(ctx).Info("restoring song, group: '", song.Group, "', song: '", song.Song, "'")
+	tag, err := db.pool.Exec(ctx, restoreFromTrashQuery, song.Group, song.Song)
+	if isSongExistsError(err) {
+		db.log(ctx).Error(ErrSongExists.Error())
+		return ErrSongExists
+	} else if err != nil {
+		db.log(ctx).Error("failed to restore song: ", err.Error())
+		return err
+	} else if tag.RowsAffected() == 0 {
+		db.log(ctx).Error(ErrSongNotFound.Error())
+		return ErrSongNotFound
+	}
+	db.log(ctx).Info("song restored")
+	return nil
+}
+
+// PurgeTrash permanently deletes songs deleted before the given time and returns their count.
+func (db *Db) PurgeTrash(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	defer contextError(ctx, &err)
+
+	db.log(ctx).Info("purging songs deleted before ", before.Format(time.RFC3339))
+	tag, err := db.pool.Exec(ctx, purgeTrashQuery, before)
+	if err != nil {
+		db.log(ctx).Error("failed to purge trash: ", err.Error())
+		return 0, err
+	}
+	db.log(ctx).Info("purged ", tag.RowsAffected(), " songs")
+	return tag.RowsAffected(), nil
+}
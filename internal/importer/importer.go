@@ -0,0 +1,307 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/songinfo"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+
+	DefaultBatchSize = 500
+	// number of concurrent song info requests while enriching a batch
+	enrichWorkers = 8
+
+	group_column        = "group"
+	song_column         = "song"
+	text_column         = "text"
+	url_column          = "url"
+	release_date_column = "release_date"
+)
+
+var (
+	ErrUnknownFormat = fmt.Errorf("unknown import format")
+	ErrBadHeader     = fmt.Errorf("CSV header must contain group and song columns")
+)
+
+type RowStatus string
+
+const (
+	RowInserted RowStatus = "inserted"
+	RowSkipped  RowStatus = "skipped"
+	RowFailed   RowStatus = "failed"
+)
+
+type RowResult struct {
+	// Row is the 1-based index of the record in the file, not counting the CSV header
+	Row    int       `json:"row"`
+	Group  string    `json:"group,omitempty"`
+	Song   string    `json:"song,omitempty"`
+	Status RowStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type Report struct {
+	Inserted int         `json:"inserted"`
+	Skipped  int         `json:"skipped"`
+	Failed   int         `json:"failed"`
+	Rows     []RowResult `json:"rows"`
+}
+
+func (r *Report) add(result RowResult) {
+	switch result.Status {
+	case RowInserted:
+		r.Inserted++
+	case RowSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+type Importer struct {
+	songs      database.SongRepository
+	song_info  *songinfo.Client
+	batch_size int
+	logger     *logger.Logger
+}
+
+func NewImporter(songs database.SongRepository, song_info *songinfo.Client, batch_size int, logger *logger.Logger) *Importer {
+	if batch_size <= 0 {
+		batch_size = DefaultBatchSize
+	}
+	return &Importer{songs: songs, song_info: song_info, batch_size: batch_size, logger: logger}
+}
+
+// record is a parsed input row. err is set if the row could not be parsed.
+type record struct {
+	row  int
+	song database.Song
+	err  error
+}
+
+// reader yields records until io.EOF.
+type reader interface {
+	next() (record, error)
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+func newCSVReader(input io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for idx, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = idx
+	}
+	if _, found := columns[group_column]; !found {
+		return nil, ErrBadHeader
+	} else if _, found = columns[song_column]; !found {
+		return nil, ErrBadHeader
+	}
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (r *csvReader) next() (record, error) {
+	fields, err := r.reader.Read()
+	if err == io.EOF {
+		return record{}, err
+	}
+	r.row++
+	if err != nil {
+		// a malformed line is reported, the following lines can still be read
+		return record{row: r.row, err: err}, nil
+	}
+	field := func(name string) string {
+		if idx, found := r.columns[name]; found && idx < len(fields) {
+			return strings.TrimSpace(fields[idx])
+		}
+		return ""
+	}
+	return record{row: r.row, song: database.Song{
+		Group:       field(group_column),
+		Song:        field(song_column),
+		Text:        field(text_column),
+		URL:         field(url_column),
+		ReleaseDate: field(release_date_column),
+	}}, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newJSONLReader(input io.Reader) *jsonlReader {
+	scanner := bufio.NewScanner(input)
+	// lyrics can make lines longer than the default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &jsonlReader{scanner: scanner}
+}
+
+func (r *jsonlReader) next() (record, error) {
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		r.row++
+		result := record{row: r.row}
+		result.err = json.Unmarshal([]byte(line), &result.song)
+		return result, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return record{}, err
+	}
+	return record{}, io.EOF
+}
+
+// log returns the request-scoped logger from the context or the default one.
+func (i *Importer) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, i.logger)
+}
+
+// Import reads songs in the given format and inserts them in batches. Songs without
+// text, URL or release date are enriched by the song info service. Duplicates of
+// existing songs are reported as skipped.
+func (i *Importer) Import(ctx context.Context, input io.Reader, format string) (Report, error) {
+	var rows reader
+	switch format {
+	case FormatCSV:
+		csv_reader, err := newCSVReader(input)
+		if err != nil {
+			i.log(ctx).Error("failed to read CSV header: ", err.Error())
+			return Report{}, err
+		}
+		rows = csv_reader
+	case FormatJSONL:
+		rows = newJSONLReader(input)
+	default:
+		i.log(ctx).Error(ErrUnknownFormat.Error(), ": ", format)
+		return Report{}, ErrUnknownFormat
+	}
+
+	i.log(ctx).Info("importing songs, format ", format, ", batch size ", i.batch_size)
+	report := Report{Rows: make([]RowResult, 0)}
+	batch := make([]record, 0, i.batch_size)
+	for {
+		next, err := rows.next()
+		if err != nil && err != io.EOF {
+			i.log(ctx).Error("failed to read import data: ", err.Error())
+			return report, err
+		}
+		if err == nil {
+			batch = append(batch, next)
+		}
+		if len(batch) == i.batch_size || (err == io.EOF && len(batch) != 0) {
+			if batch_err := i.importBatch(ctx, batch, &report); batch_err != nil {
+				return report, batch_err
+			}
+			batch = batch[:0]
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	i.log(ctx).Info("import done: inserted ", report.Inserted, ", skipped ", report.Skipped, ", failed ", report.Failed)
+	return report, nil
+}
+
+// enrich fills in missing song details from the song info service.
+func (i *Importer) enrich(ctx context.Context, song *database.Song) error {
+	if song.Group == "" || song.Song == "" {
+		return database.ErrInvalidData
+	} else if song.Text != "" && song.URL != "" && song.ReleaseDate != "" {
+		return nil
+	}
+	data, _, err := i.song_info.Get(ctx, song.Group, song.Song)
+	if err != nil {
+		return err
+	}
+	if song.Text == "" {
+		song.Text = data.Text
+	}
+	if song.URL == "" {
+		song.URL = data.URL
+	}
+	if song.ReleaseDate == "" {
+		song.ReleaseDate = data.ReleaseDate
+	}
+	return nil
+}
+
+func (i *Importer) importBatch(ctx context.Context, batch []record, report *Report) error {
+	// enrichment is done concurrently, every goroutine writes only its own record
+	semaphore := make(chan struct{}, enrichWorkers)
+	wait_group := sync.WaitGroup{}
+	for idx := range batch {
+		if batch[idx].err != nil {
+			continue
+		}
+		wait_group.Add(1)
+		semaphore <- struct{}{}
+		go func(current *record) {
+			defer wait_group.Done()
+			current.err = i.enrich(ctx, &current.song)
+			<-semaphore
+		}(&batch[idx])
+	}
+	wait_group.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	songs := make([]database.Song, 0, len(batch))
+	positions := make([]int, 0, len(batch))
+	for idx, current := range batch {
+		if current.err == nil {
+			songs = append(songs, current.song)
+			positions = append(positions, idx)
+		}
+	}
+	if len(songs) != 0 {
+		results, err := i.songs.AddSongs(ctx, songs)
+		if err != nil {
+			i.log(ctx).Error("failed to insert batch: ", err.Error())
+			return err
+		}
+		for idx, result := range results {
+			batch[positions[idx]].err = result
+		}
+	}
+
+	for _, current := range batch {
+		result := RowResult{Row: current.row, Group: current.song.Group, Song: current.song.Song, Status: RowInserted}
+		if errors.Is(current.err, database.ErrSongExists) {
+			result.Status = RowSkipped
+			result.Error = current.err.Error()
+		} else if current.err != nil {
+			result.Status = RowFailed
+			result.Error = current.err.Error()
+		}
+		report.add(result)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package ingest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/songinfo"
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 100
+)
+
+var (
+	ErrQueueFull = fmt.Errorf("ingestion queue is full")
+	ErrStopped   = fmt.Errorf("ingestion pool is stopped")
+)
+
+// Pool enriches and stores songs in the background using a fixed number of workers.
+// Jobs are persisted before they are queued, pending jobs are resumed by Start.
+type Pool struct {
+	songs     database.SongRepository
+	jobs      database.JobRepository
+	song_info *songinfo.Client
+	logger    *logger.Logger
+
+	workers uint
+	queue   chan database.Job
+	// mutex guards queue closing against concurrent Submit calls
+	mutex   sync.Mutex
+	started bool
+	stopped bool
+
+	// recovery is closed to stop feeding resumed jobs into the queue
+	recovery      chan struct{}
+	recovery_done chan struct{}
+	work_ctx      context.Context
+	cancel_work   context.CancelFunc
+	wait_group    sync.WaitGroup
+}
+
+func NewPool(workers, queue_size uint, songs database.SongRepository, jobs database.JobRepository,
+	song_info *songinfo.Client, logger *logger.Logger) *Pool {
+	if workers == 0 {
+		workers = DefaultWorkers
+	}
+	if queue_size == 0 {
+		queue_size = DefaultQueueSize
+	}
+	work_ctx, cancel_work := context.WithCancel(context.Background())
+	return &Pool{
+		songs:         songs,
+		jobs:          jobs,
+		song_info:     song_info,
+		logger:        logger,
+		workers:       workers,
+		queue:         make(chan database.Job, queue_size),
+		recovery:      make(chan struct{}),
+		recovery_done: make(chan struct{}),
+		work_ctx:      work_ctx,
+		cancel_work:   cancel_work,
+	}
+}
+
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Start launches the workers and requeues jobs left unfinished by a previous run.
+func (p *Pool) Start() error {
+	pending, err := p.jobs.GetPendingJobs(context.Background())
+	if err != nil {
+		p.logger.Error("failed to get pending ingestion jobs: ", err.Error())
+		return err
+	}
+	p.logger.Info("starting ", p.workers, " ingestion workers, resuming ", len(pending), " jobs")
+	p.mutex.Lock()
+	p.started = true
+	p.mutex.Unlock()
+
+	for i := uint(0); i < p.workers; i++ {
+		p.wait_group.Add(1)
+		go p.work()
+	}
+
+	// resumed jobs may not fit into the queue, so they are fed by a separate goroutine
+	go func() {
+		defer close(p.recovery_done)
+		for _, job := range pending {
+			select {
+			case p.queue <- job:
+			case <-p.recovery:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Submit persists a new job and queues it for processing. The artists are credited
+// besides the group when the song is added.
+func (p *Pool) Submit(ctx context.Context, group, song string, artists []database.Artist) (database.Job, error) {
+	log := logger.FromContext(ctx, p.logger)
+	if group == "" || song == "" {
+		log.Error("invalid use of Submit: group and/or song name is empty")
+		return database.Job{}, database.ErrInvalidData
+	} else if err := database.ValidateArtists(artists); err != nil {
+		log.Error(err.Error())
+		return database.Job{}, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopped {
+		log.Error(ErrStopped.Error())
+		return database.Job{}, ErrStopped
+	} else if len(p.queue) == cap(p.queue) {
+		log.Error(ErrQueueFull.Error())
+		return database.Job{}, ErrQueueFull
+	}
+
+	job := database.Job{
+		ID:        newJobID(),
+		Group:     group,
+		Song:      song,
+		Artists:   artists,
+		Status:    database.JobQueued,
+		CreatedAt: time.Now(),
+	}
+	job.UpdatedAt = job.CreatedAt
+	if err := p.jobs.AddJob(ctx, job); err != nil {
+		return database.Job{}, err
+	}
+	select {
+	case p.queue <- job:
+		log.Info("queued ingestion job ", job.ID)
+	default:
+		// resumed jobs have taken the free space
+		log.Error(ErrQueueFull.Error())
+		p.jobs.UpdateJobStatus(ctx, job.ID, database.JobFailed, ErrQueueFull.Error())
+		return database.Job{}, ErrQueueFull
+	}
+	return job, nil
+}
+
+func (p *Pool) Get(ctx context.Context, id string) (database.Job, error) {
+	return p.jobs.GetJob(ctx, id)
+}
+
+// Stop stops accepting jobs and waits for the queued ones to be processed.
+// If ctx expires first, the work in progress is cancelled; interrupted and
+// unprocessed jobs stay queued and are resumed on the next start.
+func (p *Pool) Stop(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.stopped {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.stopped = true
+	close(p.recovery)
+	if p.started {
+		<-p.recovery_done
+	}
+	close(p.queue)
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wait_group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel_work()
+		p.logger.Info("ingestion workers stopped")
+		return nil
+	case <-ctx.Done():
+		p.logger.Error("ingestion workers did not finish in time, cancelling")
+		p.cancel_work()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wait_group.Done()
+	for job := range p.queue {
+		if p.work_ctx.Err() != nil {
+			// left queued for the next start
+			continue
+		}
+		p.process(job)
+	}
+}
+
+func (p *Pool) process(job database.Job) {
+	log := p.logger.With("job_id", job.ID)
+	// the status is updated even if the work is cancelled
+	status_ctx := logger.NewContext(context.Background(), log)
+	work_ctx := logger.NewContext(p.work_ctx, log)
+
+	log.Info("processing ingestion job ", job.ID, ": group '", job.Group, "', song '", job.Song, "'")
+	if err := p.jobs.UpdateJobStatus(status_ctx, job.ID, database.JobRunning, ""); err != nil {
+		return
+	}
+
+	data, date, err := p.song_info.Get(work_ctx, job.Group, job.Song)
+	if err == nil {
+		err = p.songs.AddSong(work_ctx, job.Group, job.Song, data.Text, data.URL, date, job.Artists, "")
+	}
+
+	// the storage reports its interrupted operations with ErrCanceled instead of the context error
+	if errors.Is(err, context.Canceled) || errors.Is(err, database.ErrCanceled) {
+		log.Info("ingestion job ", job.ID, " interrupted")
+		p.jobs.UpdateJobStatus(status_ctx, job.ID, database.JobQueued, "")
+	} else if err != nil {
+		log.Error("ingestion job ", job.ID, " failed: ", err.Error())
+		p.jobs.UpdateJobStatus(status_ctx, job.ID, database.JobFailed, err.Error())
+	} else {
+		log.Info("ingestion job ", job.ID, " succeeded")
+		p.jobs.UpdateJobStatus(status_ctx, job.ID, database.JobSucceeded, "")
+	}
+}
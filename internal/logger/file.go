@@ -0,0 +1,315 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FileMode is the mode of new log files, logs may contain user data
+	FileMode = 0640
+
+	backup_time_format = "2006-01-02T15-04-05.000"
+	compressed_suffix  = ".gz"
+)
+
+var ErrFileClosed = fmt.Errorf("log file is closed")
+
+type FileConfig struct {
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated, 0 disables size-based rotation
+	MaxSize int64
+	// Interval is the period after which the file is rotated, 0 disables time-based rotation
+	Interval time.Duration
+	// MaxAge is how long rotated files are kept, 0 keeps them regardless of age
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept, 0 keeps all of them
+	MaxBackups int
+	// Compress enables gzip of rotated files
+	Compress bool
+}
+
+// File is a log file that is rotated by size and age. A rotated file is renamed to
+// <name>-<timestamp><ext> next to the log file, optionally compressed, and removed
+// once it exceeds the retention limits. Files rotated within the same millisecond
+// get a -<n> suffix after the timestamp. File is safe for concurrent use.
+type File struct {
+	config FileConfig
+
+	mutex     sync.Mutex
+	file      *os.File
+	size      int64
+	opened_at time.Time
+
+	// rotated files are compressed and old ones removed in the background
+	cleanup      chan struct{}
+	cleanup_done chan struct{}
+}
+
+// OpenFile opens or creates the log file and starts the background cleanup of rotated files.
+func OpenFile(config FileConfig) (*File, error) {
+	f := &File{
+		config:       config,
+		cleanup:      make(chan struct{}, 1),
+		cleanup_done: make(chan struct{}),
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.runCleanup()
+	// files rotated before a restart may still exceed the limits
+	f.scheduleCleanup()
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, FileMode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened_at = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *File) Write(data []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return 0, ErrFileClosed
+	}
+	if f.shouldRotate(int64(len(data))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	written, err := f.file.Write(data)
+	f.size += int64(written)
+	return written, err
+}
+
+func (f *File) shouldRotate(write_size int64) bool {
+	if f.size == 0 {
+		// an empty file is never rotated, even if a single record exceeds the limit
+		return false
+	}
+	return f.config.MaxSize > 0 && f.size+write_size > f.config.MaxSize ||
+		f.config.Interval > 0 && time.Since(f.opened_at) >= f.config.Interval
+}
+
+// Rotate renames the current file and continues writing to a new one.
+func (f *File) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return ErrFileClosed
+	}
+	return f.rotate()
+}
+
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if err := os.Rename(f.config.Path, f.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		// keep writing to the old file rather than losing the logs
+		if open_err := f.open(); open_err != nil {
+			return open_err
+		}
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.scheduleCleanup()
+	return nil
+}
+
+// Reopen closes the file and opens the path again. It is used after the file was moved
+// by an external tool such as logrotate.
+func (f *File) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return ErrFileClosed
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	return f.open()
+}
+
+// Close closes the file and waits for the background cleanup to finish.
+func (f *File) Close() error {
+	f.mutex.Lock()
+	if f.file == nil {
+		f.mutex.Unlock()
+		return ErrFileClosed
+	}
+	err := f.file.Close()
+	f.file = nil
+	close(f.cleanup)
+	f.mutex.Unlock()
+
+	<-f.cleanup_done
+	return err
+}
+
+func (f *File) scheduleCleanup() {
+	select {
+	case f.cleanup <- struct{}{}:
+	default:
+		// a cleanup is already pending
+	}
+}
+
+func (f *File) runCleanup() {
+	defer close(f.cleanup_done)
+	for range f.cleanup {
+		f.cleanupBackups()
+	}
+}
+
+func (f *File) splitPath() (prefix string, ext string) {
+	ext = filepath.Ext(f.config.Path)
+	return strings.TrimSuffix(f.config.Path, ext) + "-", ext
+}
+
+// backupName returns a name for the file rotated at rotated_at that isn't taken by another
+// rotated file, compressed or not, so that renaming the file never overwrites one.
+func (f *File) backupName(rotated_at time.Time) string {
+	prefix, ext := f.splitPath()
+	name := prefix + rotated_at.Format(backup_time_format)
+	result := name + ext
+	for sequence := 1; backupExists(result); sequence++ {
+		result = name + "-" + strconv.Itoa(sequence) + ext
+	}
+	return result
+}
+
+func backupExists(path string) bool {
+	for _, name := range []string{path, path + compressed_suffix} {
+		if _, err := os.Lstat(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type backup struct {
+	path       string
+	rotated_at time.Time
+	// sequence orders files rotated in the same millisecond
+	sequence int
+}
+
+// backups returns the rotated files, newest first.
+func (f *File) backups() ([]backup, error) {
+	prefix, ext := f.splitPath()
+	entries, err := os.ReadDir(filepath.Dir(f.config.Path))
+	if err != nil {
+		return nil, err
+	}
+	name_prefix := filepath.Base(prefix)
+	result := []backup{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, name_prefix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimSuffix(name[len(name_prefix):], compressed_suffix), ext)
+		if len(timestamp) < len(backup_time_format) {
+			continue
+		}
+		rotated_at, err := time.ParseInLocation(backup_time_format, timestamp[:len(backup_time_format)], time.Local)
+		if err != nil {
+			continue
+		}
+		sequence := 0
+		if suffix := timestamp[len(backup_time_format):]; suffix != "" {
+			number, found := strings.CutPrefix(suffix, "-")
+			if sequence, err = strconv.Atoi(number); !found || err != nil || sequence < 1 {
+				continue
+			}
+		}
+		result = append(result, backup{
+			path:       filepath.Join(filepath.Dir(f.config.Path), name),
+			rotated_at: rotated_at,
+			sequence:   sequence,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].rotated_at.Equal(result[j].rotated_at) {
+			return result[i].rotated_at.After(result[j].rotated_at)
+		}
+		return result[i].sequence > result[j].sequence
+	})
+	return result, nil
+}
+
+// cleanupBackups removes rotated files that exceed the retention limits and compresses the rest.
+// Errors are written to stderr: the log file itself may be the problem.
+func (f *File) cleanupBackups() {
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to list rotated log files:", err.Error())
+		return
+	}
+	for i, file := range backups {
+		if f.config.MaxBackups > 0 && i >= f.config.MaxBackups ||
+			f.config.MaxAge > 0 && time.Since(file.rotated_at) > f.config.MaxAge {
+			if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintln(os.Stderr, "failed to remove rotated log file:", err.Error())
+			}
+		} else if f.config.Compress && !strings.HasSuffix(file.path, compressed_suffix) {
+			if err := compressFile(file.path); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to compress rotated log file:", err.Error())
+			}
+		}
+	}
+}
+
+// compressFile replaces the file with its gzip-compressed copy.
+func compressFile(path string) error {
+	input, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+
+	compressed_path := path + compressed_suffix
+	output, err := os.OpenFile(compressed_path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(output)
+	if _, err = io.Copy(writer, input); err == nil {
+		err = writer.Close()
+	}
+	if close_err := output.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		os.Remove(compressed_path)
+		return err
+	}
+	return os.Remove(path)
+}
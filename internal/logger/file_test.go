@@ -0,0 +1,259 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestFile(t *testing.T, config FileConfig) *File {
+	t.Helper()
+	if config.Path == "" {
+		config.Path = filepath.Join(t.TempDir(), "app.log")
+	}
+	f, err := OpenFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func write(t *testing.T, f *File, records ...string) {
+	t.Helper()
+	for _, record := range records {
+		if _, err := f.Write([]byte(record)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+}
+
+// closeFile closes the file, which waits for the cleanup of rotated files.
+func closeFile(t *testing.T, f *File) {
+	t.Helper()
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(path, compressed_suffix) {
+		gzip_reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		reader = gzip_reader
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return string(content)
+}
+
+// backupContents returns the contents of the rotated files, newest first.
+func backupContents(t *testing.T, f *File) []string {
+	t.Helper()
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	for _, backup := range backups {
+		result = append(result, readFile(t, backup.path))
+	}
+	return result
+}
+
+func TestRotateBySize(t *testing.T) {
+	f := openTestFile(t, FileConfig{MaxSize: 10})
+	write(t, f, "record 1\n", "record 2\n", "record 3\n")
+	closeFile(t, f)
+
+	if content := readFile(t, f.config.Path); content != "record 3\n" {
+		t.Errorf("log file = %q", content)
+	}
+	// the rotations usually happen within the same millisecond
+	if backups := backupContents(t, f); !slices.Equal(backups, []string{"record 2\n", "record 1\n"}) {
+		t.Errorf("backups = %q", backups)
+	}
+}
+
+func TestSizeLimitAllowsOneRecord(t *testing.T) {
+	f := openTestFile(t, FileConfig{MaxSize: 4})
+	write(t, f, "a long record\n")
+	closeFile(t, f)
+
+	if content := readFile(t, f.config.Path); content != "a long record\n" {
+		t.Errorf("log file = %q", content)
+	}
+	if backups := backupContents(t, f); len(backups) != 0 {
+		t.Errorf("backups = %q", backups)
+	}
+}
+
+func TestRotateKeepsEveryBackup(t *testing.T) {
+	f := openTestFile(t, FileConfig{})
+	want := []string{}
+	for _, record := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		write(t, f, record)
+		if err := f.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+		want = slices.Insert(want, 0, record)
+	}
+	closeFile(t, f)
+
+	if backups := backupContents(t, f); !slices.Equal(backups, want) {
+		t.Errorf("backups = %q, want %q", backups, want)
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	f := &File{config: FileConfig{Path: filepath.Join(t.TempDir(), "app.log")}}
+	rotated_at := time.Date(2024, 5, 1, 10, 20, 30, 400_000_000, time.Local)
+	prefix := strings.TrimSuffix(f.config.Path, ".log") + "-2024-05-01T10-20-30.400"
+
+	names := []string{}
+	for range 3 {
+		name := f.backupName(rotated_at)
+		if err := os.WriteFile(name, nil, FileMode); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	// a compressed backup takes the name as well
+	if err := os.Rename(names[2], names[2]+compressed_suffix); err != nil {
+		t.Fatal(err)
+	}
+	names = append(names, f.backupName(rotated_at))
+
+	want := []string{prefix + ".log", prefix + "-1.log", prefix + "-2.log", prefix + "-3.log"}
+	if !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+}
+
+func TestBackupsOrder(t *testing.T) {
+	dir := t.TempDir()
+	f := &File{config: FileConfig{Path: filepath.Join(dir, "app.log")}}
+	files := []string{
+		"app-2024-05-01T10-20-30.400-1.log.gz",
+		"app-2024-05-01T10-20-30.400.log.gz",
+		"app-2024-05-01T10-20-30.401.log",
+		"app-2024-05-01T10-20-30.400-2.log",
+		// not rotated files
+		"app.log",
+		"app-2024-05-01T10-20-30.400-x.log",
+		"app-2024-05-01T10-20-30.400-0.log",
+		"app-2024-05-01.log",
+		"other-2024-05-01T10-20-30.400.log",
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, FileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, backup := range backups {
+		names = append(names, filepath.Base(backup.path))
+	}
+	want := []string{
+		"app-2024-05-01T10-20-30.401.log",
+		"app-2024-05-01T10-20-30.400-2.log",
+		"app-2024-05-01T10-20-30.400-1.log.gz",
+		"app-2024-05-01T10-20-30.400.log.gz",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("backups = %q, want %q", names, want)
+	}
+}
+
+func TestMaxBackups(t *testing.T) {
+	f := openTestFile(t, FileConfig{MaxBackups: 2})
+	for _, record := range []string{"1\n", "2\n", "3\n", "4\n"} {
+		write(t, f, record)
+		if err := f.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+	closeFile(t, f)
+
+	if backups := backupContents(t, f); !slices.Equal(backups, []string{"4\n", "3\n"}) {
+		t.Errorf("backups = %q", backups)
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	// files rotated before the file was opened are cleaned up as well
+	f := &File{config: FileConfig{Path: path}}
+	old := f.backupName(time.Now().Add(-2 * time.Hour))
+	recent := f.backupName(time.Now().Add(-time.Minute))
+	for _, name := range []string{old, recent} {
+		if err := os.WriteFile(name, []byte("old\n"), FileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f = openTestFile(t, FileConfig{Path: path, MaxAge: time.Hour})
+	closeFile(t, f)
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("backup older than MaxAge kept: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent backup removed: %v", err)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	f := openTestFile(t, FileConfig{Compress: true, MaxBackups: 2})
+	for _, record := range []string{"1\n", "2\n", "3\n"} {
+		write(t, f, record)
+		if err := f.Rotate(); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+	closeFile(t, f)
+
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup.path, compressed_suffix) {
+			t.Errorf("%s is not compressed", backup.path)
+		}
+	}
+	if contents := backupContents(t, f); !slices.Equal(contents, []string{"3\n", "2\n"}) {
+		t.Errorf("backups = %q", contents)
+	}
+}
+
+func TestClosedFile(t *testing.T) {
+	f := openTestFile(t, FileConfig{})
+	closeFile(t, f)
+	if _, err := f.Write([]byte("record\n")); err != ErrFileClosed {
+		t.Errorf("Write = %v, want ErrFileClosed", err)
+	}
+	if err := f.Rotate(); err != ErrFileClosed {
+		t.Errorf("Rotate = %v, want ErrFileClosed", err)
+	}
+}
@@ -1,33 +1,150 @@
 package logger
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// LevelFatal is logged by Fatal before the process exits
+	LevelFatal = slog.LevelError + 4
+)
+
+var (
+	ErrUnknownFormat = fmt.Errorf("unknown log format")
+	ErrUnknownLevel  = fmt.Errorf("unknown log level")
+)
+
+type Config struct {
+	// Format is FormatText (default) or FormatJSON
+	Format string
+	// Level is the minimum level: debug, info (default), warn or error
+	Level string
+}
+
+// Logger writes leveled structured records through log/slog. Messages are built from
+// the arguments like fmt.Sprint, attributes are added with With.
+// The minimum level is shared by the logger and all loggers derived from it.
 type Logger struct {
-	err   *log.Logger
-	debug *log.Logger
-	info  *log.Logger
-	fatal *log.Logger
+	handler slog.Handler
+	level   *slog.LevelVar
 }
 
-func NewLogger(out io.Writer) *Logger {
-	return &Logger{
-		err:   log.New(out, "ERROR: ", log.LstdFlags|log.Lshortfile),
-		debug: log.New(out, "DEBUG: ", log.LstdFlags|log.Lshortfile),
-		info:  log.New(out, "INFO: ", log.LstdFlags),
-		fatal: log.New(out, "FATAL: ", log.LstdFlags|log.Lshortfile),
+func ParseLevel(level string) (slog.Level, error) {
+	var result slog.Level
+	if strings.EqualFold(level, "fatal") {
+		return LevelFatal, nil
+	} else if err := result.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("%w '%s'", ErrUnknownLevel, level)
 	}
+	return result, nil
+}
 
+// LevelName returns the name of the level as accepted by ParseLevel.
+func LevelName(level slog.Level) string {
+	if level == LevelFatal {
+		return "FATAL"
+	}
+	return level.String()
+}
+
+func NewLogger(out io.Writer, config Config) (*Logger, error) {
+	level := &slog.LevelVar{}
+	if config.Level != "" {
+		parsed, err := ParseLevel(config.Level)
+		if err != nil {
+			return nil, err
+		}
+		level.Set(parsed)
+	}
+
+	options := &slog.HandlerOptions{AddSource: true, Level: level, ReplaceAttr: replaceAttr}
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", FormatText:
+		handler = slog.NewTextHandler(out, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(out, options)
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownFormat, config.Format)
+	}
+	return &Logger{handler: handler, level: level}, nil
+}
+
+// replaceAttr shortens the source to file:line and names the fatal level.
+func replaceAttr(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) != 0 {
+		return attr
+	}
+	switch attr.Key {
+	case slog.SourceKey:
+		if source, ok := attr.Value.Any().(*slog.Source); ok {
+			return slog.String(slog.SourceKey, filepath.Base(source.File)+":"+strconv.Itoa(source.Line))
+		}
+	case slog.LevelKey:
+		if level, ok := attr.Value.Any().(slog.Level); ok {
+			return slog.String(slog.LevelKey, LevelName(level))
+		}
+	}
+	return attr
+}
+
+// With returns a logger that adds the key/value pairs to every record, see slog.Logger.With.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{handler: slog.New(l.handler).With(args...).Handler(), level: l.level}
+}
+
+// SetLevel changes the minimum level of the logger and all loggers derived from it.
+func (l *Logger) SetLevel(level slog.Level) {
+	l.level.Set(level)
+}
+
+func (l *Logger) Level() slog.Level {
+	return l.level.Level()
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger, usually one with request-scoped attributes.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger stored by NewContext or fallback if there is none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+func (l *Logger) log(level slog.Level, v ...any) {
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, level) {
+		return
+	}
+	// skip runtime.Callers, log and the exported method to report the caller
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), level, fmt.Sprint(v...), pcs[0])
+	l.handler.Handle(ctx, record)
 }
 
-func (l *Logger) Info(v ...any)  { l.info.Output(2, fmt.Sprint(v...)) }
-func (l *Logger) Debug(v ...any) { l.debug.Output(2, fmt.Sprint(v...)) }
-func (l *Logger) Error(v ...any) { l.err.Output(2, fmt.Sprint(v...)) }
+func (l *Logger) Info(v ...any)  { l.log(slog.LevelInfo, v...) }
+func (l *Logger) Debug(v ...any) { l.log(slog.LevelDebug, v...) }
+func (l *Logger) Error(v ...any) { l.log(slog.LevelError, v...) }
 func (l *Logger) Fatal(v ...any) {
-	l.fatal.Output(2, fmt.Sprint(v...))
+	l.log(LevelFatal, v...)
 	os.Exit(1)
 }
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "songs"
+
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	http_requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of handled HTTP requests by route, method and response status.",
+	}, []string{"route", "method", "status"})
+	http_duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request handling latency by route, method and response status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	db_duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by statement name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"statement"})
+	db_errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Number of failed database queries by statement name.",
+	}, []string{"statement"})
+
+	song_info_requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "song_info_requests_total",
+		Help:      "Number of song info lookups by result.",
+	}, []string{"result"})
+	song_info_duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "song_info_request_duration_seconds",
+		Help:      "Song info lookup latency, including retries, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+)
+
+// Handler serves all registered metrics in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records a handled HTTP request. route must be the matched pattern,
+// not the request path, to keep the label set bounded.
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	status_label := strconv.Itoa(status)
+	http_requests.WithLabelValues(route, method, status_label).Inc()
+	http_duration.WithLabelValues(route, method, status_label).Observe(duration.Seconds())
+}
+
+func ObserveQuery(statement string, duration time.Duration, err error) {
+	db_duration.WithLabelValues(statement).Observe(duration.Seconds())
+	if err != nil {
+		db_errors.WithLabelValues(statement).Inc()
+	}
+}
+
+func ObserveSongInfo(duration time.Duration, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultFailure
+	}
+	song_info_requests.WithLabelValues(result).Inc()
+	song_info_duration.WithLabelValues(result).Observe(duration.Seconds())
+}
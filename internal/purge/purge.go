@@ -0,0 +1,74 @@
+package purge
+
+import (
+	"context"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+const (
+	DefaultRetention = 30 * 24 * time.Hour
+	DefaultInterval  = time.Hour
+)
+
+// Purger periodically deletes songs that have been in the trash longer than the retention period.
+type Purger struct {
+	trash     database.TrashRepository
+	retention time.Duration
+	interval  time.Duration
+	logger    *logger.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewPurger(trash database.TrashRepository, retention, interval time.Duration, logger *logger.Logger) *Purger {
+	if retention == 0 {
+		retention = DefaultRetention
+	}
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return &Purger{
+		trash:     trash,
+		retention: retention,
+		interval:  interval,
+		logger:    logger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start purges the trash immediately and then once per interval until Stop is called.
+func (p *Purger) Start() {
+	p.logger.Info("starting trash purge, retention ", p.retention, ", interval ", p.interval)
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			// errors are logged by the repository, the next run retries
+			p.trash.PurgeTrash(context.Background(), time.Now().Add(-p.retention))
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the purge loop and waits for a purge in progress unless ctx expires first.
+func (p *Purger) Stop(ctx context.Context) error {
+	close(p.stop)
+	select {
+	case <-p.done:
+		p.logger.Info("trash purge stopped")
+		return nil
+	case <-ctx.Done():
+		p.logger.Error("trash purge did not finish in time")
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+const (
+	v2_albums_path = "/v2/albums"
+	v2_album_path  = "/v2/groups/{group}/albums/{album}"
+	v2_track_path  = "/v2/groups/{group}/albums/{album}/tracks/{n}"
+
+	album_key      = "album"
+	album_path_key = "album"
+	track_path_key = "n"
+	tracks_key     = "tracks"
+)
+
+type albumData struct {
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+	CoverURL    string `json:"cover_url"`
+}
+
+type trackData struct {
+	Song               string `json:"song"`
+	InheritReleaseDate bool   `json:"inherit_release_date"`
+}
+
+func (s *Server) registerAlbums(mux *http.ServeMux) {
+	s.handle(mux, http.MethodGet+" "+v2_albums_path, database.RoleReader, s.getAlbumsV2)
+	s.handle(mux, http.MethodPost+" "+v2_albums_path, database.RoleEditor, s.createAlbumV2)
+	s.handle(mux, http.MethodGet+" "+v2_album_path, database.RoleReader, s.getAlbumV2)
+	s.handle(mux, http.MethodPatch+" "+v2_album_path, database.RoleEditor, s.patchAlbumV2)
+	s.handle(mux, http.MethodDelete+" "+v2_album_path, database.RoleAdmin, s.deleteAlbumV2)
+	s.handle(mux, http.MethodPut+" "+v2_track_path, database.RoleEditor, s.putTrackV2)
+	s.handle(mux, http.MethodDelete+" "+v2_track_path, database.RoleEditor, s.deleteTrackV2)
+	mux.HandleFunc(v2_albums_path, s.methodNotAllowed("GET, POST"))
+	mux.HandleFunc(v2_album_path, s.methodNotAllowed("GET, PATCH, DELETE"))
+	mux.HandleFunc(v2_track_path, s.methodNotAllowed("PUT, DELETE"))
+}
+
+func albumLocation(group, title string) string {
+	return v2_groups_path + "/" + url.PathEscape(group) + "/albums/" + url.PathEscape(title)
+}
+
+func (s *Server) getAlbumsV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 album list request")
+	query := request.URL.Query()
+	page_idx, page_size, success := s.getPageIdxAndSize(query, writer, request)
+	if !success {
+		return
+	}
+	if len(query[group_key]) > 1 {
+		s.log(request).Error("expected a single value for ", group_key, " get parameter, got ", len(query[group_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, group_key, "expected a single value")
+		return
+	}
+
+	result, err := s.db.GetAlbums(request.Context(), query.Get(group_key), page_idx, page_size)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) createAlbumV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to add an album")
+	album := database.Album{}
+	if !s.parseJSON(&album, writer, request) {
+		return
+	}
+	if album.Group == "" || album.Title == "" || album.ReleaseDate == "" {
+		s.log(request).Error("group, title and release date are required")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_data, "", "group, title and release_date are required")
+		return
+	}
+	date, success := s.parseDate(album.ReleaseDate, writer, request)
+	if !success {
+		return
+	}
+
+	err := s.db.AddAlbum(request.Context(), album.Group, album.Title, *date, album.CoverURL, album.Tracks, requestAuthor(request))
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	// the tracks are returned with their release dates
+	created, err := s.db.GetAlbum(request.Context(), album.Group, album.Title)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.Header().Set("Location", albumLocation(album.Group, album.Title))
+	if s.writeJSON(created, http.StatusCreated, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) getAlbumV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 album retrieval request")
+	album, err := s.db.GetAlbum(request.Context(), request.PathValue(group_path_key), request.PathValue(album_path_key))
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(album, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) patchAlbumV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to update album details")
+	group, title := request.PathValue(group_path_key), request.PathValue(album_path_key)
+	data := albumData{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	date, success := s.parseDate(data.ReleaseDate, writer, request)
+	if !success {
+		return
+	}
+
+	// UpdateAlbum does not report missing albums when there is nothing to update
+	if data.Title == "" && data.CoverURL == "" && date == nil {
+		if _, err := s.db.GetAlbum(request.Context(), group, title); err != nil {
+			s.writeDBResponse(err, writer, request)
+			return
+		}
+	} else if err := s.db.UpdateAlbum(request.Context(), group, title, data.Title, data.CoverURL, date, requestAuthor(request)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+
+	if data.Title != "" {
+		writer.Header().Set("Location", albumLocation(group, data.Title))
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+func (s *Server) deleteAlbumV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to delete album")
+	if err := s.db.DeleteAlbum(request.Context(), request.PathValue(group_path_key), request.PathValue(album_path_key)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+// putTrackV2 attaches a song of the album's group as the track with the number from the path.
+func (s *Server) putTrackV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to set album track")
+	number, success := s.parsePositiveNumber(request.PathValue(track_path_key), track_path_key, writer, request)
+	if !success {
+		return
+	}
+	data := trackData{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	if data.Song == "" {
+		s.log(request).Error("song is required")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_data, song_key, "song is required")
+		return
+	}
+
+	track := database.Track{Number: number, Song: data.Song, InheritReleaseDate: data.InheritReleaseDate}
+	err := s.db.SetTrack(request.Context(), request.PathValue(group_path_key), request.PathValue(album_path_key), track, requestAuthor(request))
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+func (s *Server) deleteTrackV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to remove album track")
+	number, success := s.parsePositiveNumber(request.PathValue(track_path_key), track_path_key, writer, request)
+	if !success {
+		return
+	}
+	err := s.db.RemoveTrack(request.Context(), request.PathValue(group_path_key), request.PathValue(album_path_key), number)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Onlymiind/test_task/internal/auth"
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+const (
+	api_key_header = "X-API-Key"
+	bearer_prefix  = "Bearer "
+
+	admin_keys_path = "/admin/keys"
+	admin_key_path  = "/admin/keys/{id}"
+	key_id_path_key = "id"
+)
+
+type issueKeyRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type issuedKey struct {
+	database.APIKey
+	// Key is returned only once, when the key is issued
+	Key string `json:"key"`
+}
+
+// requestCredential returns the API key or the token from the X-API-Key or the Authorization header.
+func requestCredential(request *http.Request) string {
+	if key := request.Header.Get(api_key_header); key != "" {
+		return key
+	}
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) > len(bearer_prefix) && strings.EqualFold(authorization[:len(bearer_prefix)], bearer_prefix) {
+		return strings.TrimSpace(authorization[len(bearer_prefix):])
+	}
+	return ""
+}
+
+// authenticate checks a JWT bearer token if token validation is enabled and the credential
+// looks like one, otherwise the credential is treated as an API key.
+func (s *Server) authenticate(request *http.Request) (auth.Identity, error) {
+	credential := requestCredential(request)
+	if s.tokens != nil && request.Header.Get(api_key_header) == "" && auth.IsToken(credential) {
+		return s.tokens.Verify(credential)
+	} else if s.keys == nil {
+		return auth.Identity{}, auth.ErrUnauthenticated
+	}
+	return s.keys.Authenticate(request.Context(), credential)
+}
+
+// require lets the request through if the caller has at least the given role.
+// The caller identity and a logger that adds it to every record are stored in the request context.
+func (s *Server) require(role database.Role, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if s.keys == nil && s.tokens == nil {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		identity, err := s.authenticate(request)
+		if err == auth.ErrUnauthenticated {
+			s.log(request).Error("unauthenticated request to ", request.URL.Path)
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="songs"`)
+			s.writeProblem(writer, request, http.StatusUnauthorized, code_unauthenticated, "", "missing or invalid credentials")
+			return
+		} else if err != nil {
+			s.log(request).Error("failed to authenticate request: ", err.Error())
+			s.writeInternalError(writer, request)
+			return
+		}
+		if !auth.Allows(identity.Role, role) {
+			s.log(request).Error("'", identity.Name, "' with role ", identity.Role, " is not allowed to access ", request.URL.Path)
+			s.writeProblem(writer, request, http.StatusForbidden, code_forbidden, "", "role "+string(role)+" required")
+			return
+		}
+		log := s.log(request).With("subject", identity.Name, "role", identity.Role)
+		log.Info(request.Method, " ", request.URL.Path)
+		ctx := logger.NewContext(auth.WithIdentity(request.Context(), identity), log)
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+func (s *Server) handle(mux *http.ServeMux, pattern string, role database.Role, handler http.HandlerFunc) {
+	mux.Handle(pattern, s.require(role, handler))
+}
+
+func (s *Server) registerAdmin(mux *http.ServeMux) {
+	s.handle(mux, http.MethodGet+" "+admin_keys_path, database.RoleAdmin, s.listKeys)
+	s.handle(mux, http.MethodPost+" "+admin_keys_path, database.RoleAdmin, s.issueKey)
+	s.handle(mux, http.MethodDelete+" "+admin_key_path, database.RoleAdmin, s.revokeKey)
+	mux.HandleFunc(admin_keys_path, s.methodNotAllowed("GET, POST"))
+	mux.HandleFunc(admin_key_path, s.methodNotAllowed(http.MethodDelete))
+	s.registerLogLevel(mux)
+}
+
+// keysEnabled reports a problem if key management is requested while authentication is disabled.
+func (s *Server) keysEnabled(writer http.ResponseWriter, request *http.Request) bool {
+	if s.keys != nil {
+		return true
+	}
+	s.log(request).Error("API key management requested, but authentication is disabled")
+	s.writeProblem(writer, request, http.StatusNotFound, code_path_not_found, "", "authentication is disabled")
+	return false
+}
+
+func (s *Server) listKeys(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received API key list request")
+	if !s.keysEnabled(writer, request) {
+		return
+	}
+	keys, err := s.keys.List(request.Context())
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(keys, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) issueKey(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received API key issue request")
+	if !s.keysEnabled(writer, request) {
+		return
+	}
+	data := issueKeyRequest{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	role, err := auth.ParseRole(data.Role)
+	if err != nil {
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, "role", "expected reader, editor or admin")
+		return
+	}
+	key, secret, err := s.keys.Issue(request.Context(), data.Name, role)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.Header().Set("Location", admin_keys_path+"/"+key.ID)
+	if s.writeJSON(issuedKey{APIKey: key, Key: secret}, http.StatusCreated, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) revokeKey(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received API key revoke request")
+	if !s.keysEnabled(writer, request) {
+		return
+	}
+	if err := s.keys.Revoke(request.Context(), request.PathValue(key_id_path_key)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/ingest"
+	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/songinfo"
+)
+
+const (
+	problem_content_type = "application/problem+json"
+	problem_type_prefix  = "/errors/"
+	request_id_header    = "X-Request-ID"
+
+	max_request_id_length = 128
+
+	// error codes are part of the API and must not be changed
+	code_invalid_argument    = "invalid_argument"
+	code_invalid_json        = "invalid_json"
+	code_invalid_data        = "invalid_data"
+	code_method_not_allowed  = "method_not_allowed"
+	code_path_not_found      = "path_not_found"
+	code_group_not_found     = "group_not_found"
+	code_song_not_found      = "song_not_found"
+	code_verse_not_found     = "verse_not_found"
+	code_song_exists         = "song_exists"
+	code_page_out_of_bounds  = "page_out_of_bounds"
+	code_empty_filter        = "empty_filter"
+	code_no_output           = "no_output"
+	code_upstream_failure    = "upstream_failure"
+	code_upstream_down       = "upstream_unavailable"
+	code_internal_error      = "internal_error"
+	code_request_read_failed = "request_read_failed"
+	code_job_not_found       = "job_not_found"
+	code_queue_full          = "queue_full"
+	code_ingestion_stopped   = "ingestion_stopped"
+	code_revision_not_found  = "revision_not_found"
+	code_unauthenticated     = "unauthenticated"
+	code_forbidden           = "forbidden"
+	code_key_not_found       = "key_not_found"
+	code_db_timeout          = "database_timeout"
+	code_request_canceled    = "request_canceled"
+	code_invalid_cursor      = "invalid_cursor"
+	code_album_not_found     = "album_not_found"
+	code_album_exists        = "album_exists"
+	code_track_not_found     = "track_not_found"
+)
+
+// problem is an RFC 7807 problem details document.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id"`
+}
+
+type dbProblem struct {
+	status int
+	code   string
+	field  string
+	detail string
+}
+
+var dbProblems = map[error]dbProblem{
+	database.ErrInvalidData:      {http.StatusBadRequest, code_invalid_data, "", "group and/or song name is empty"},
+	database.ErrGroupNotFound:    {http.StatusNotFound, code_group_not_found, group_key, "non-existent group"},
+	database.ErrSongNotFound:     {http.StatusNotFound, code_song_not_found, song_key, "non-existent song"},
+	database.ErrSongExists:       {http.StatusConflict, code_song_exists, song_key, "song already exists"},
+	database.ErrPageOutOfBounds:  {http.StatusBadRequest, code_page_out_of_bounds, page_idx_key, "page out of bounds"},
+	database.ErrEmptyFilter:      {http.StatusBadRequest, code_empty_filter, search_query_key, "empty filter"},
+	database.ErrNoOutput:         {http.StatusInternalServerError, code_no_output, "", "unexpected empty database response"},
+	database.ErrJobNotFound:      {http.StatusNotFound, code_job_not_found, job_id_path_key, "job not found"},
+	database.ErrRevisionNotFound: {http.StatusNotFound, code_revision_not_found, revision_key, "revision not found"},
+	database.ErrKeyNotFound:      {http.StatusNotFound, code_key_not_found, key_id_path_key, "API key not found"},
+	database.ErrInvalidCursor:    {http.StatusBadRequest, code_invalid_cursor, cursor_key, "invalid cursor"},
+	database.ErrAlbumNotFound:    {http.StatusNotFound, code_album_not_found, album_key, "non-existent album"},
+	database.ErrAlbumExists:      {http.StatusConflict, code_album_exists, album_key, "album already exists"},
+	database.ErrTrackNotFound:    {http.StatusNotFound, code_track_not_found, track_path_key, "non-existent track"},
+	database.ErrInvalidTracks:    {http.StatusBadRequest, code_invalid_data, tracks_key, "track numbers must be positive and unique, songs must be unique"},
+	database.ErrInvalidArtists:   {http.StatusBadRequest, code_invalid_data, artists_key, "artists must have a name and a known role and be unique"},
+	database.ErrTimeout:          {http.StatusGatewayTimeout, code_db_timeout, "", "database operation timed out"},
+	// the client has usually gone away by then
+	database.ErrCanceled: {http.StatusServiceUnavailable, code_request_canceled, "", "request canceled"},
+	ingest.ErrQueueFull:  {http.StatusServiceUnavailable, code_queue_full, "", "ingestion queue is full"},
+	ingest.ErrStopped:    {http.StatusServiceUnavailable, code_ingestion_stopped, "", "ingestion is stopped"},
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// validRequestID reports whether an incoming request ID is short and printable enough
+// to be echoed in the response and written to the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > max_request_id_length {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID takes the request ID from the X-Request-ID header or assigns a new one and echoes it
+// in the response headers so that problem documents can refer to it.
+// The request context carries a logger that adds the ID to every record.
+func (s *Server) withRequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get(request_id_header)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		writer.Header().Set(request_id_header, id)
+		ctx := logger.NewContext(request.Context(), s.logger.With("request_id", id))
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// log returns the logger of the request, see withRequestID.
+func (s *Server) log(request *http.Request) *logger.Logger {
+	return logger.FromContext(request.Context(), s.logger)
+}
+
+func (s *Server) writeProblem(writer http.ResponseWriter, request *http.Request, status int, code, field, detail string) {
+	result := problem{
+		Type:      problem_type_prefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		Field:     field,
+		RequestID: writer.Header().Get(request_id_header),
+	}
+	result_bytes, err := json.Marshal(result)
+	if err != nil {
+		s.log(request).Error("failed to encode problem as JSON: ", err.Error())
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", problem_content_type)
+	writer.WriteHeader(status)
+	if _, err = writer.Write(result_bytes); err != nil {
+		s.log(request).Error("failed to write response: ", err.Error())
+	}
+}
+
+func (s *Server) writeInternalError(writer http.ResponseWriter, request *http.Request) {
+	s.writeProblem(writer, request, http.StatusInternalServerError, code_internal_error, "", "")
+}
+
+func (s *Server) notFound(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Error("path not found: ", request.URL.Path)
+	s.writeProblem(writer, request, http.StatusNotFound, code_path_not_found, "", "path "+request.URL.Path+" not found")
+}
+
+// methodNotAllowed handles requests to known paths with an unsupported method.
+// http.ServeMux would otherwise answer with a plain text body.
+func (s *Server) methodNotAllowed(allowed string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		s.log(request).Error("request method is '", request.Method, "', expected one of ", allowed)
+		writer.Header().Set("Allow", allowed)
+		s.writeProblem(writer, request, http.StatusMethodNotAllowed, code_method_not_allowed, "", "expected "+allowed)
+	}
+}
+
+// writeSongInfoError reports a failed song info lookup. failure_status is used
+// unless the upstream is known to be unavailable.
+func (s *Server) writeSongInfoError(err error, failure_status int, writer http.ResponseWriter, request *http.Request) {
+	if err == songinfo.ErrCircuitOpen {
+		writer.Header().Set("Retry-After", strconv.Itoa(int(s.song_info.RetryAfter().Seconds())+1))
+		s.writeProblem(writer, request, http.StatusServiceUnavailable, code_upstream_down, "", "song info service is unavailable")
+		return
+	}
+	s.writeProblem(writer, request, failure_status, code_upstream_failure, "", "failed to get song info")
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+const (
+	export_path = "/export"
+
+	export_csv   = "csv"
+	export_jsonl = "jsonl"
+	export_xml   = "xml"
+)
+
+var export_content_types = map[string]string{
+	export_csv:   "text/csv; charset=utf-8",
+	export_jsonl: "application/x-ndjson",
+	export_xml:   "application/xml; charset=utf-8",
+}
+
+// songEncoder writes exported songs in one of the export formats.
+type songEncoder interface {
+	begin() error
+	encode(song database.Song) error
+	end() error
+}
+
+type csvSongEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvSongEncoder) begin() error {
+	return e.writer.Write([]string{"group", "song", "text", "url", "release_date"})
+}
+
+func (e *csvSongEncoder) encode(song database.Song) error {
+	return e.writer.Write([]string{song.Group, song.Song, song.Text, song.URL, song.ReleaseDate})
+}
+
+func (e *csvSongEncoder) end() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlSongEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlSongEncoder) begin() error {
+	return nil
+}
+
+func (e *jsonlSongEncoder) encode(song database.Song) error {
+	return e.encoder.Encode(song)
+}
+
+func (e *jsonlSongEncoder) end() error {
+	return nil
+}
+
+type xmlSongEncoder struct {
+	encoder *xml.Encoder
+}
+
+var xml_library_element = xml.StartElement{Name: xml.Name{Local: "library"}}
+
+func (e *xmlSongEncoder) begin() error {
+	if err := e.encoder.EncodeToken(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="UTF-8"`)}); err != nil {
+		return err
+	}
+	return e.encoder.EncodeToken(xml_library_element)
+}
+
+func (e *xmlSongEncoder) encode(song database.Song) error {
+	return e.encoder.EncodeElement(song, xml.StartElement{Name: xml.Name{Local: "song"}})
+}
+
+func (e *xmlSongEncoder) end() error {
+	if err := e.encoder.EncodeToken(xml_library_element.End()); err != nil {
+		return err
+	}
+	return e.encoder.Flush()
+}
+
+func newSongEncoder(format string, writer io.Writer) songEncoder {
+	switch format {
+	case export_csv:
+		return &csvSongEncoder{writer: csv.NewWriter(writer)}
+	case export_jsonl:
+		encoder := json.NewEncoder(writer)
+		encoder.SetEscapeHTML(false)
+		return &jsonlSongEncoder{encoder: encoder}
+	case export_xml:
+		return &xmlSongEncoder{encoder: xml.NewEncoder(writer)}
+	}
+	return nil
+}
+
+// exportSongs streams the whole filtered library. The response status is sent with the
+// first exported song, so errors before it are still reported as problem documents.
+// Later errors abort the connection to make the truncation visible to the client.
+func (s *Server) exportSongs(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received library export request")
+	query := request.URL.Query()
+	format := query.Get(format_key)
+	encoder := newSongEncoder(format, writer)
+	if encoder == nil {
+		s.log(request).Error("unknown export format: '", format, "'")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, format_key, "expected csv, jsonl or xml format")
+		return
+	}
+	filter, success := s.getLibraryFilter(query, writer, request)
+	if !success {
+		return
+	}
+
+	started := false
+	start := func() error {
+		started = true
+		writer.Header().Set("Content-Type", export_content_types[format])
+		writer.Header().Set("Content-Disposition", `attachment; filename="library.`+format+`"`)
+		writer.WriteHeader(http.StatusOK)
+		return encoder.begin()
+	}
+	err := s.db.ExportSongs(request.Context(), filter, func(song database.Song) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return encoder.encode(song)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+
+	if err != nil && !started {
+		s.writeDBResponse(err, writer, request)
+		return
+	} else if err != nil {
+		s.log(request).Error("export failed after the response was started: ", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	s.log(request).Info("success")
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+const (
+	group_match_key     = "group_match"
+	song_match_key      = "song_match"
+	released_after_key  = "released_after"
+	released_before_key = "released_before"
+	year_key            = "year"
+	decade_key          = "decade"
+	sort_key            = "sort"
+	album_group_key     = "album_group"
+
+	sort_descending = "desc"
+	sort_ascending  = "asc"
+)
+
+var matchModes = map[string]database.MatchMode{
+	"":                             database.MatchLike,
+	string(database.MatchLike):     database.MatchLike,
+	string(database.MatchExact):    database.MatchExact,
+	string(database.MatchPrefix):   database.MatchPrefix,
+	string(database.MatchContains): database.MatchContains,
+}
+
+var sortColumns = map[string]database.SortColumn{
+	group_key:        database.SortGroup,
+	song_key:         database.SortSong,
+	release_date_key: database.SortReleaseDate,
+}
+
+// getLibraryFilter parses the filter and sort parameters shared by /get_all and /export.
+// The release date bounds of released_after, released_before, year and decade are intersected.
+func (s *Server) getLibraryFilter(query url.Values, writer http.ResponseWriter, request *http.Request) (database.LibraryFilter, bool) {
+	filter := database.LibraryFilter{}
+	for _, group := range query[group_key] {
+		if group != "" {
+			filter.Groups = append(filter.Groups, group)
+		}
+	}
+	if len(query[song_key]) > 1 {
+		s.log(request).Error("expected a single value for ", song_key, " get parameter, got ", len(query[song_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, song_key, "expected a single value")
+		return database.LibraryFilter{}, false
+	}
+	filter.Song = query.Get(song_key)
+	if len(query[album_key]) > 1 {
+		s.log(request).Error("expected a single value for ", album_key, " get parameter, got ", len(query[album_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, album_key, "expected a single value")
+		return database.LibraryFilter{}, false
+	}
+	filter.Album = query.Get(album_key)
+	if len(query[album_group_key]) > 1 {
+		s.log(request).Error("expected a single value for ", album_group_key, " get parameter, got ", len(query[album_group_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, album_group_key, "expected a single value")
+		return database.LibraryFilter{}, false
+	}
+	filter.AlbumGroup = query.Get(album_group_key)
+	// album titles are only unique within a group
+	if (filter.Album == "") != (filter.AlbumGroup == "") {
+		s.log(request).Error(album_key, " and ", album_group_key, " must be used together")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, album_group_key, "album and album_group must be used together")
+		return database.LibraryFilter{}, false
+	}
+
+	var success bool
+	if filter.GroupMatch, success = s.getMatchMode(query, group_match_key, writer, request); !success {
+		return database.LibraryFilter{}, false
+	}
+	if filter.SongMatch, success = s.getMatchMode(query, song_match_key, writer, request); !success {
+		return database.LibraryFilter{}, false
+	}
+	if filter.ReleaseDate, success = s.getDateParam(query, release_date_key, writer, request); !success {
+		return database.LibraryFilter{}, false
+	}
+	if filter.ReleasedAfter, success = s.getDateParam(query, released_after_key, writer, request); !success {
+		return database.LibraryFilter{}, false
+	}
+	if filter.ReleasedBefore, success = s.getDateParam(query, released_before_key, writer, request); !success {
+		return database.LibraryFilter{}, false
+	}
+
+	for _, period := range []struct {
+		key   string
+		years uint
+	}{{year_key, 1}, {decade_key, 10}} {
+		if len(query[period.key]) == 0 {
+			continue
+		}
+		start, success := s.parseUintGetParam(query, period.key, writer, request)
+		if !success {
+			return database.LibraryFilter{}, false
+		} else if start%period.years != 0 || start > 9999 {
+			s.log(request).Error("invalid ", period.key, ": ", start)
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, period.key, "expected a year, a multiple of ten for a decade")
+			return database.LibraryFilter{}, false
+		}
+		after := time.Date(int(start), time.January, 1, 0, 0, 0, 0, time.UTC)
+		before := after.AddDate(int(period.years), 0, -1)
+		if filter.ReleasedAfter == nil || after.After(*filter.ReleasedAfter) {
+			filter.ReleasedAfter = &after
+		}
+		if filter.ReleasedBefore == nil || before.Before(*filter.ReleasedBefore) {
+			filter.ReleasedBefore = &before
+		}
+	}
+
+	if len(query[sort_key]) > 1 {
+		s.log(request).Error("expected a single value for ", sort_key, " get parameter, got ", len(query[sort_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, sort_key, "expected a single value")
+		return database.LibraryFilter{}, false
+	} else if len(query[sort_key]) == 1 {
+		if filter.Sort, success = s.parseSort(query[sort_key][0], writer, request); !success {
+			return database.LibraryFilter{}, false
+		}
+	}
+	return filter, true
+}
+
+func (s *Server) getMatchMode(query url.Values, key string, writer http.ResponseWriter, request *http.Request) (database.MatchMode, bool) {
+	mode, known := matchModes[query.Get(key)]
+	if len(query[key]) > 1 || !known {
+		s.log(request).Error("invalid ", key, ": ", query[key])
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected like, exact, prefix or contains")
+		return "", false
+	}
+	return mode, true
+}
+
+// getDateParam parses an optional date parameter.
+func (s *Server) getDateParam(query url.Values, key string, writer http.ResponseWriter, request *http.Request) (*time.Time, bool) {
+	if len(query[key]) == 0 {
+		return nil, true
+	} else if len(query[key]) != 1 {
+		s.log(request).Error("expected exactly one value for ", key, " get parameter, got ", len(query[key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected a single value")
+		return nil, false
+	}
+	date, err := time.Parse(database.DateFmt, query[key][0])
+	if err != nil {
+		s.log(request).Error("failed to parse ", key, ": ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected a date in DD.MM.YYYY format")
+		return nil, false
+	}
+	return &date, true
+}
+
+// parseSort parses a comma-separated list of column[:asc|desc].
+func (s *Server) parseSort(value string, writer http.ResponseWriter, request *http.Request) ([]database.SortField, bool) {
+	result := []database.SortField{}
+	seen := map[database.SortColumn]bool{}
+	for _, item := range strings.Split(value, ",") {
+		name, direction, _ := strings.Cut(strings.TrimSpace(item), ":")
+		column, known := sortColumns[name]
+		if !known || seen[column] || (direction != "" && direction != sort_ascending && direction != sort_descending) {
+			s.log(request).Error("invalid sort order '", value, "'")
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, sort_key,
+				"expected a list of distinct group, song or release_date with optional :asc or :desc")
+			return nil, false
+		}
+		seen[column] = true
+		result = append(result, database.SortField{Column: column, Descending: direction == sort_descending})
+	}
+	return result, true
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	healthz_path = "/healthz"
+	readyz_path  = "/readyz"
+
+	ready_check_timeout = 2 * time.Second
+
+	status_ok          = "ok"
+	status_unavailable = "unavailable"
+)
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (s *Server) registerHealth(mux *http.ServeMux) {
+	mux.HandleFunc(http.MethodGet+" "+healthz_path, s.healthz)
+	mux.HandleFunc(healthz_path, s.methodNotAllowed(http.MethodGet))
+	mux.HandleFunc(http.MethodGet+" "+readyz_path, s.readyz)
+	mux.HandleFunc(readyz_path, s.methodNotAllowed(http.MethodGet))
+}
+
+// healthz reports that the process is alive and serving requests.
+func (s *Server) healthz(writer http.ResponseWriter, request *http.Request) {
+	s.writeJSON(healthResponse{Status: status_ok}, http.StatusOK, writer, request)
+}
+
+// readyz reports whether the service can handle requests: the database is reachable and
+// migrated and, if enabled, the song info service is reachable.
+func (s *Server) readyz(writer http.ResponseWriter, request *http.Request) {
+	ctx, cancel := context.WithTimeout(request.Context(), ready_check_timeout)
+	defer cancel()
+
+	result := healthResponse{Status: status_ok, Checks: map[string]string{"database": status_ok}}
+	if err := s.db.Ready(ctx); err != nil {
+		result.Status = status_unavailable
+		result.Checks["database"] = err.Error()
+	}
+	if s.check_song_info {
+		result.Checks["song_info"] = status_ok
+		if err := s.song_info.Ready(ctx); err != nil {
+			result.Status = status_unavailable
+			result.Checks["song_info"] = err.Error()
+		}
+	}
+
+	status := http.StatusOK
+	if result.Status != status_ok {
+		s.log(request).Error("service is not ready: ", result.Checks)
+		status = http.StatusServiceUnavailable
+	}
+	s.writeJSON(result, status, writer, request)
+}
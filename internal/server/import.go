@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/csv"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/Onlymiind/test_task/internal/importer"
+)
+
+const (
+	import_path = "/import"
+	format_key  = "format"
+)
+
+// import_content_types maps request content types to import formats
+var import_content_types = map[string]string{
+	"text/csv":              importer.FormatCSV,
+	"application/csv":       importer.FormatCSV,
+	"application/x-ndjson":  importer.FormatJSONL,
+	"application/jsonl":     importer.FormatJSONL,
+	"application/jsonlines": importer.FormatJSONL,
+}
+
+// importFormat returns the format from the query, falling back to the request content type.
+func importFormat(request *http.Request) string {
+	if format := request.URL.Query().Get(format_key); format != "" {
+		return format
+	}
+	media_type, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return import_content_types[media_type]
+}
+
+func (s *Server) importSongs(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received song import request")
+	format := importFormat(request)
+	if format != importer.FormatCSV && format != importer.FormatJSONL {
+		s.log(request).Error("unknown import format: '", format, "'")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, format_key, "expected csv or jsonl format")
+		return
+	}
+
+	report, err := s.importer.Import(request.Context(), request.Body, format)
+	var parse_err *csv.ParseError
+	if err == importer.ErrBadHeader || errors.As(err, &parse_err) {
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_data, "", err.Error())
+		return
+	} else if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(report, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
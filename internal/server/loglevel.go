@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+const log_level_path = "/admin/log_level"
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) registerLogLevel(mux *http.ServeMux) {
+	s.handle(mux, http.MethodGet+" "+log_level_path, database.RoleAdmin, s.getLogLevel)
+	s.handle(mux, http.MethodPut+" "+log_level_path, database.RoleAdmin, s.setLogLevel)
+	mux.HandleFunc(log_level_path, s.methodNotAllowed("GET, PUT"))
+}
+
+func (s *Server) getLogLevel(writer http.ResponseWriter, request *http.Request) {
+	s.writeJSON(logLevel{Level: logger.LevelName(s.log(request).Level())}, http.StatusOK, writer, request)
+}
+
+// setLogLevel changes the minimum log level until the next restart.
+func (s *Server) setLogLevel(writer http.ResponseWriter, request *http.Request) {
+	data := logLevel{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	level, err := logger.ParseLevel(data.Level)
+	if err != nil {
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, "level", "expected debug, info, warn or error")
+		return
+	}
+	previous := s.log(request).Level()
+	s.log(request).SetLevel(level)
+	// logged at the error level so that the change is visible at any level
+	s.log(request).Error("log level changed from ", logger.LevelName(previous), " to ", logger.LevelName(level), " by '", requestAuthor(request), "'")
+	s.writeJSON(logLevel{Level: logger.LevelName(level)}, http.StatusOK, writer, request)
+}
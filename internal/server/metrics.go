@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/metrics"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	metrics_path    = "/metrics"
+	unmatched_route = "unmatched"
+)
+
+// statusRecorder remembers the response status for the metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// withMetrics counts requests and measures their latency by the matched route pattern and
+// names the request span after it. It must wrap the mux directly: the mux stores the matched
+// pattern in the request.
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer}
+		defer func() {
+			route := request.Pattern
+			if route == "" {
+				route = unmatched_route
+			} else if _, path, found := strings.Cut(route, " "); found {
+				// the method is a label on its own
+				route = path
+			}
+			status := recorder.status
+			if status == 0 {
+				// the server replies 200 if the handler wrote nothing
+				status = http.StatusOK
+			}
+			metrics.ObserveRequest(route, request.Method, status, time.Since(start))
+
+			span := trace.SpanFromContext(request.Context())
+			span.SetName(request.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}()
+		mux.ServeHTTP(recorder, request)
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+func revisionLocation(group, song string, number int) string {
+	return songLocation(group, song) + "/revisions/" + strconv.Itoa(number)
+}
+
+func (s *Server) parsePositiveNumber(value, key string, writer http.ResponseWriter, request *http.Request) (int, bool) {
+	number, err := strconv.ParseUint(value, 10, 31)
+	if err != nil || number == 0 {
+		s.log(request).Error("failed to parse revision number '", value, "'")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected a positive integer")
+		return 0, false
+	}
+	return int(number), true
+}
+
+func (s *Server) getRevisionsV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 song revisions request")
+	revisions, err := s.db.GetRevisions(request.Context(), songFromPath(request))
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(revisions, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) getRevisionV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 song revision request")
+	number, success := s.parsePositiveNumber(request.PathValue(revision_key), revision_key, writer, request)
+	if !success {
+		return
+	}
+	revision, err := s.db.GetRevision(request.Context(), songFromPath(request), number)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(revision, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+// diffRevisionsV2 compares two revisions, by default the latest one with the one before it.
+func (s *Server) diffRevisionsV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 song revision diff request")
+	entry := songFromPath(request)
+	query := request.URL.Query()
+	revisions, err := s.db.GetRevisions(request.Context(), entry)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+
+	to := len(revisions)
+	from := max(to-1, 1)
+	success := true
+	if query.Has(diff_from_key) {
+		if from, success = s.parsePositiveNumber(query.Get(diff_from_key), diff_from_key, writer, request); !success {
+			return
+		}
+	}
+	if query.Has(diff_to_key) {
+		if to, success = s.parsePositiveNumber(query.Get(diff_to_key), diff_to_key, writer, request); !success {
+			return
+		}
+	}
+	if from > len(revisions) || to > len(revisions) || to == 0 {
+		s.writeDBResponse(database.ErrRevisionNotFound, writer, request)
+		return
+	}
+
+	diff := database.DiffRevisions(revisions[from-1], revisions[to-1])
+	if s.writeJSON(diff, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) restoreRevisionV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 song revision restore request")
+	number, success := s.parsePositiveNumber(request.PathValue(revision_key), revision_key, writer, request)
+	if !success {
+		return
+	}
+	revision, err := s.db.RestoreRevision(request.Context(), songFromPath(request), number, requestAuthor(request))
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.Header().Set("Location", revisionLocation(revision.Current.Group, revision.Current.Song, revision.Number))
+	if s.writeJSON(revision, http.StatusCreated, writer, request) {
+		s.log(request).Info("success")
+	}
+}
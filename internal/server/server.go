@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,8 +12,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Onlymiind/test_task/internal/auth"
 	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/importer"
+	"github.com/Onlymiind/test_task/internal/ingest"
 	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/metrics"
+	"github.com/Onlymiind/test_task/internal/songinfo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 const (
@@ -21,22 +28,38 @@ const (
 	get_song_path    = "/get_song"
 	delete_song_path = "/delete_song"
 	change_song_path = "/change_song"
-	song_info_path   = "/info"
+	search_path      = "/search"
+	job_path         = "/jobs/{id}"
 
 	default_page_size = 20
 	page_size_key     = "page_size"
 	page_idx_key      = "page_idx"
+	cursor_key        = "cursor"
+	count_key         = "count"
 	song_key          = "song"
 	group_key         = "group"
+	artists_key       = "artists"
 	release_date_key  = "release_date"
+	search_query_key  = "q"
+	async_key         = "async"
+	job_id_path_key   = "id"
+	prefer_header     = "Prefer"
+	prefer_async      = "respond-async"
+	author_header     = "X-Author"
 )
 
 var ErrWrongArgument = fmt.Errorf("wrong argument type")
 
 type Server struct {
-	db            *database.Db
-	song_info_url string
-	logger        *logger.Logger
+	db        database.SongRepository
+	song_info *songinfo.Client
+	jobs      *ingest.Pool
+	importer  *importer.Importer
+	keys      *auth.Keys
+	tokens    *auth.Tokens
+	// check_song_info makes readiness depend on the song info service
+	check_song_info bool
+	logger          *logger.Logger
 }
 
 type changeSongRequest struct {
@@ -46,6 +69,13 @@ type changeSongRequest struct {
 	NewText        string                `json:"new_text"`
 	NewURL         string                `json:"new_url"`
 	NewReleaseDate string                `json:"new_release_date"`
+	// NewArtists replace the credits besides the group if present
+	NewArtists []database.Artist `json:"new_artists"`
+}
+
+type addSongRequest struct {
+	database.LibraryEntry
+	Artists []database.Artist `json:"artists"`
 }
 
 type songTextResponse struct {
@@ -58,19 +88,47 @@ type songData struct {
 	Text        string `json:"text"`
 	ReleaseDate string `json:"release_date"`
 	URL         string `json:"url"`
+	// Artists replace the credits besides the group if present
+	Artists []database.Artist `json:"artists"`
 }
 
-func Init(db *database.Db, song_info_url string, logger *logger.Logger) {
+// Init registers the HTTP handlers. If both keys and tokens are nil, authentication is disabled.
+func Init(db database.SongRepository, song_info *songinfo.Client, jobs *ingest.Pool, importer *importer.Importer,
+	keys *auth.Keys, tokens *auth.Tokens, check_song_info bool, logger *logger.Logger) {
 	server := &Server{
-		db:            db,
-		song_info_url: song_info_url,
-		logger:        logger,
-	}
-	http.Handle(add_song_path, server)
-	http.Handle(get_all_path, server)
-	http.Handle(get_song_path, server)
-	http.Handle(delete_song_path, server)
-	http.Handle(change_song_path, server)
+		db:              db,
+		song_info:       song_info,
+		jobs:            jobs,
+		importer:        importer,
+		keys:            keys,
+		tokens:          tokens,
+		check_song_info: check_song_info,
+		logger:          logger,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(add_song_path, server.require(database.RoleEditor, server))
+	mux.Handle(get_all_path, server.require(database.RoleReader, server))
+	mux.Handle(get_song_path, server.require(database.RoleReader, server))
+	mux.Handle(delete_song_path, server.require(database.RoleAdmin, server))
+	mux.Handle(change_song_path, server.require(database.RoleEditor, server))
+	mux.Handle(search_path, server.require(database.RoleReader, server))
+	server.registerV2(mux)
+	server.handle(mux, http.MethodGet+" "+job_path, database.RoleReader, server.getJob)
+	mux.HandleFunc(job_path, server.methodNotAllowed(http.MethodGet))
+	server.handle(mux, http.MethodPost+" "+import_path, database.RoleEditor, server.importSongs)
+	mux.HandleFunc(import_path, server.methodNotAllowed(http.MethodPost))
+	server.handle(mux, http.MethodGet+" "+export_path, database.RoleReader, server.exportSongs)
+	mux.HandleFunc(export_path, server.methodNotAllowed(http.MethodGet))
+	server.handle(mux, http.MethodGet+" "+trash_path, database.RoleAdmin, server.getTrash)
+	mux.HandleFunc(trash_path, server.methodNotAllowed(http.MethodGet))
+	server.handle(mux, http.MethodPost+" "+trash_restore_path, database.RoleAdmin, server.restoreSong)
+	mux.HandleFunc(trash_restore_path, server.methodNotAllowed(http.MethodPost))
+	server.registerAdmin(mux)
+	server.registerHealth(mux)
+	mux.Handle(http.MethodGet+" "+metrics_path, metrics.Handler())
+	mux.HandleFunc(metrics_path, server.methodNotAllowed(http.MethodGet))
+	mux.HandleFunc("/", server.notFound)
+	http.Handle("/", otelhttp.NewHandler(server.withRequestID(withMetrics(mux)), "request"))
 }
 
 func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
@@ -85,129 +143,131 @@ func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 		s.changeSong(writer, request)
 	case get_song_path:
 		s.getSong(writer, request)
+	case search_path:
+		s.search(writer, request)
 	default:
-		writer.WriteHeader(http.StatusNotFound)
-		s.logger.Error("path not found: ", request.URL.Path)
-		return
+		s.notFound(writer, request)
 	}
 }
 
 func (s *Server) getAll(writer http.ResponseWriter, request *http.Request) {
-	s.logger.Info("received library retrieval request")
-	if !s.validateRequestMethod(request.Method, http.MethodGet, writer) {
+	s.log(request).Info("received library retrieval request")
+	if !s.validateRequestMethod(request.Method, http.MethodGet, writer, request) {
 		return
 	}
 
 	query := request.URL.Query()
-	page_idx, page_size, success := s.getPageIdxAndSize(query, writer)
+	page_idx, page_size, success := s.getPageIdxAndSize(query, writer, request)
 	if !success {
 		return
 	}
-	song_filter, group_filter, err := s.getSongAndGroup(query, writer)
-	if err != nil {
+	filter, success := s.getLibraryFilter(query, writer, request)
+	if !success {
 		return
 	}
-	var date *time.Time
-	if len(query[release_date_key]) != 0 {
-		if len(query[release_date_key]) != 1 {
-			s.logger.Error("expected exactly one value for ", release_date_key, " get parameter, got ", len(query[release_date_key]))
-			writer.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		date_val, err := time.Parse(database.DateFmt, query[release_date_key][0])
-		if err != nil {
-			s.logger.Error("failed to parse release date: ", err.Error())
-			writer.WriteHeader(http.StatusBadRequest)
+
+	var result database.LibraryPage
+	var err error
+	if query.Has(cursor_key) {
+		// an empty cursor requests the first page
+		cursor, with_count, success := s.getCursorAndCount(query, writer, request)
+		if !success {
 			return
 		}
-		date = &date_val
+		result, err = s.db.GetFilteredByCursor(request.Context(), filter, cursor, page_size, with_count)
+	} else {
+		result, err = s.db.GetFiltered(request.Context(), filter, page_idx, page_size)
 	}
-
-	result, err := s.db.GetFiltered(group_filter, song_filter, page_idx, page_size, date)
 	if err != nil {
-		s.writeDBResponse(err, writer)
+		s.writeDBResponse(err, writer, request)
 		return
 	}
 	if result.Entries == nil {
 		result.Entries = make([]database.LibraryEntry, 0, 0)
 	}
 
-	result_bytes, err := json.Marshal(result)
-	if err != nil {
-		s.logger.Error("failed to encode library as JSON: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+
+}
+
+func (s *Server) search(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received lyrics search request")
+	if !s.validateRequestMethod(request.Method, http.MethodGet, writer, request) {
 		return
 	}
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Add("Content-Type", "application/json")
-	_, err = writer.Write(result_bytes)
-	if err != nil {
-		s.logger.Error("failed to write response: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
+
+	query := request.URL.Query()
+	page_idx, page_size, success := s.getPageIdxAndSize(query, writer, request)
+	if !success {
+		return
+	}
+	if len(query[search_query_key]) != 1 {
+		s.log(request).Error("expected a single value for ", search_query_key, " get parameter, got ", len(query[search_query_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, search_query_key, "expected a single value")
 		return
 	}
-	s.logger.Info("success")
 
+	result, err := s.db.Search(request.Context(), query[search_query_key][0], page_idx, page_size)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if result.Entries == nil {
+		result.Entries = make([]database.SearchResult, 0)
+	}
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
 }
 
 func (s *Server) getSong(writer http.ResponseWriter, request *http.Request) {
-	s.logger.Info("received song info retrieval request")
-	if !s.validateRequestMethod(request.Method, http.MethodGet, writer) {
+	s.log(request).Info("received song info retrieval request")
+	if !s.validateRequestMethod(request.Method, http.MethodGet, writer, request) {
 		return
 	}
 
 	query := request.URL.Query()
-	song, group, err := s.getSongAndGroup(query, writer)
+	song, group, err := s.getSongAndGroup(query, writer, request)
 	if err != nil {
 		return
 	}
 
-	text, err := s.db.GetSongText(group, song)
+	text, err := s.db.GetSongText(request.Context(), group, song)
 	if err != nil {
-		s.writeDBResponse(err, writer)
+		s.writeDBResponse(err, writer, request)
 		return
 	}
 
 	verses := strings.Split(text, "\n\n")
 	page_idx := 0
 	if len(query[page_idx_key]) != 0 {
-		page_idx_unsigned, success := s.parseUintGetParam(query, page_idx_key, writer)
+		page_idx_unsigned, success := s.parseUintGetParam(query, page_idx_key, writer, request)
 		if !success {
 			return
 		} else if page_idx_unsigned > math.MaxInt {
-			s.logger.Error("page index too big: ", page_idx_unsigned)
-			writer.WriteHeader(http.StatusBadRequest)
+			s.log(request).Error("page index too big: ", page_idx_unsigned)
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, page_idx_key, "page index too big")
 			return
 		}
 		page_idx = int(page_idx_unsigned)
 	}
 	if page_idx >= len(verses) {
-		s.logger.Error("page index out of bounds, size: ", len(verses), ", index: ", page_idx)
-		writer.WriteHeader(http.StatusBadRequest)
+		s.log(request).Error("page index out of bounds, size: ", len(verses), ", index: ", page_idx)
+		s.writeProblem(writer, request, http.StatusBadRequest, code_page_out_of_bounds, page_idx_key, "page out of bounds")
 		return
 	}
 
 	result := songTextResponse{PageIndex: page_idx, PageCount: len(verses), Verse: verses[page_idx]}
-	result_bytes, err := json.Marshal(result)
-	if err != nil {
-		s.logger.Error("failed to encode song verse as JSON: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
 	}
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Add("Content-Type", "application/json")
-	_, err = writer.Write(result_bytes)
-	if err != nil {
-		s.logger.Error("failed to write response: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	s.logger.Info("success")
 }
 
 func (s *Server) deleteSong(writer http.ResponseWriter, request *http.Request) {
-	s.logger.Info("received request to delete song")
-	if !s.validateRequestMethod(request.Method, http.MethodPost, writer) {
+	s.log(request).Info("received request to delete song")
+	if !s.validateRequestMethod(request.Method, http.MethodPost, writer, request) {
 		return
 	}
 
@@ -216,14 +276,14 @@ func (s *Server) deleteSong(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	if s.writeDBResponse(s.db.DeleteSong(song), writer) {
-		s.logger.Info("success")
+	if s.writeDBResponse(s.db.DeleteSong(request.Context(), song), writer, request) {
+		s.log(request).Info("success")
 	}
 }
 
 func (s *Server) changeSong(writer http.ResponseWriter, request *http.Request) {
-	s.logger.Info("received request to update song details")
-	if !s.validateRequestMethod(request.Method, http.MethodPost, writer) {
+	s.log(request).Info("received request to update song details")
+	if !s.validateRequestMethod(request.Method, http.MethodPost, writer, request) {
 		return
 	}
 
@@ -234,184 +294,188 @@ func (s *Server) changeSong(writer http.ResponseWriter, request *http.Request) {
 
 	var date *time.Time
 	if data.NewReleaseDate != "" {
-		date_val, err := time.Parse("02.01.2006", data.NewReleaseDate)
+		date_val, err := time.Parse(database.DateFmt, data.NewReleaseDate)
 		if err != nil {
-			s.logger.Error("failed to parse release date: ", err.Error())
-			writer.WriteHeader(http.StatusBadRequest)
+			s.log(request).Error("failed to parse release date: ", err.Error())
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, "new_release_date", "expected a date in DD.MM.YYYY format")
 			return
 		}
 		date = &date_val
 	}
 
-	if s.writeDBResponse(s.db.UpdateSong(data.Song, data.NewGroup, data.NewName,
-		data.NewText, data.NewURL, date), writer) {
-		s.logger.Info("success")
+	if s.writeDBResponse(s.db.UpdateSong(request.Context(), data.Song, data.NewGroup, data.NewName,
+		data.NewText, data.NewURL, date, data.NewArtists, requestAuthor(request)), writer, request) {
+		s.log(request).Info("success")
 	}
 }
 
 func (s *Server) addSong(writer http.ResponseWriter, request *http.Request) {
-	s.logger.Info("received request to add a song to the library")
-	if request.Body == nil || request.ContentLength == 0 {
-		writer.WriteHeader(http.StatusBadRequest)
-		s.logger.Error("missing song info")
-		return
-	}
-
-	body := make([]byte, request.ContentLength)
-	s.logger.Debug("add song request: length ", request.Header.Get("content-length"), " content-type ", request.Header.Get("content-type"))
-	_, err := request.Body.Read(body)
-	if err != io.EOF {
-		writer.WriteHeader(http.StatusInternalServerError)
-		s.logger.Error("failed to read request's body")
+	s.log(request).Info("received request to add a song to the library")
+	s.log(request).Debug("add song request: length ", request.Header.Get("content-length"), " content-type ", request.Header.Get("content-type"))
+	song := addSongRequest{}
+	if !s.parseJSON(&song, writer, request) {
 		return
 	}
-	song := database.LibraryEntry{}
-	err = json.Unmarshal(body, &song)
-	if err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		s.logger.Error("failed to parse JSON")
+	if isAsyncRequest(request) {
+		s.addSongAsync(song, writer, request)
 		return
 	}
 
-	get_params := url.Values{"group": {song.Group}, "name": {song.Song}}
-	request_url := strings.Join([]string{s.song_info_url, song_info_path}, "/")
-	request_url += "?" + get_params.Encode()
-	s.logger.Info("sending song info request to: ", request_url)
-	response, err := http.Get(request_url)
+	song_data, date, err := s.song_info.Get(request.Context(), song.Group, song.Song)
 	if err != nil {
-		s.logger.Error("failed to get song info: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if response.StatusCode != http.StatusOK {
-		s.logger.Error("failed to get song info, response status: ", response.Status)
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if response.Header.Get("content-type") != "application/json" {
-		s.logger.Error("unexpected content type in response")
-		writer.WriteHeader(http.StatusInternalServerError)
+		s.writeSongInfoError(err, http.StatusInternalServerError, writer, request)
 		return
 	}
+	err = s.db.AddSong(request.Context(), song.Group, song.Song, song_data.Text, song_data.URL, date, song.Artists, requestAuthor(request))
+	if s.writeDBResponse(err, writer, request) {
+		s.log(request).Info("success")
+	}
 
-	body = make([]byte, response.ContentLength)
-	_, err = response.Body.Read(body)
-	if err != nil && err != io.EOF {
-		s.logger.Error("failed to read response body: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
+}
+
+// isAsyncRequest reports whether the client asked to process the request in the background,
+// either with the async=true parameter or with the "Prefer: respond-async" header.
+func isAsyncRequest(request *http.Request) bool {
+	if async, err := strconv.ParseBool(request.URL.Query().Get(async_key)); err == nil && async {
+		return true
 	}
-	song_data := songData{}
-	err = json.Unmarshal(body, &song_data)
+	for _, preference := range request.Header.Values(prefer_header) {
+		for _, token := range strings.Split(preference, ",") {
+			if strings.TrimSpace(token) == prefer_async {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) addSongAsync(song addSongRequest, writer http.ResponseWriter, request *http.Request) {
+	job, err := s.jobs.Submit(request.Context(), song.Group, song.Song, song.Artists)
 	if err != nil {
-		s.logger.Error("failed to parse the response: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
+		s.writeDBResponse(err, writer, request)
 		return
 	}
-	if song_data.Text == "" {
-		s.logger.Error("song text empty")
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
-	} else if song_data.URL == "" {
-		s.logger.Error("song url empty")
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
+	writer.Header().Set("Location", "/jobs/"+job.ID)
+	if s.writeJSON(job, http.StatusAccepted, writer, request) {
+		s.log(request).Info("song ingestion queued, job ", job.ID)
 	}
+}
 
-	date, err := time.Parse(database.DateFmt, song_data.ReleaseDate)
+func (s *Server) getJob(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received job status request")
+	job, err := s.jobs.Get(request.Context(), request.PathValue(job_id_path_key))
 	if err != nil {
-		s.logger.Error("failed to parse release date")
-		writer.WriteHeader(http.StatusInternalServerError)
+		s.writeDBResponse(err, writer, request)
 		return
 	}
-	if s.writeDBResponse(s.db.AddSong(song.Group, song.Song, song_data.Text, song_data.URL, date), writer) {
-		s.logger.Info("success")
+	if s.writeJSON(job, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
 	}
+}
 
+// requestAuthor returns the name recorded as the author of the changes made by the request:
+// the API key name, or the X-Author header if authentication is disabled.
+func requestAuthor(request *http.Request) string {
+	if identity, found := auth.FromContext(request.Context()); found {
+		return identity.Name
+	}
+	return request.Header.Get(author_header)
 }
 
-func (s *Server) validateRequestMethod(method, expected string, writer http.ResponseWriter) bool {
+func (s *Server) validateRequestMethod(method, expected string, writer http.ResponseWriter, request *http.Request) bool {
 	if method == expected {
 		return true
 	}
-	s.logger.Error("request method is '", method, "', expected ", expected)
-	writer.WriteHeader(http.StatusBadRequest)
-	writer.Write(([]byte)("expected "))
-	writer.Write(([]byte)(expected))
+	s.log(request).Error("request method is '", method, "', expected ", expected)
+	writer.Header().Set("Allow", expected)
+	s.writeProblem(writer, request, http.StatusMethodNotAllowed, code_method_not_allowed, "", "expected "+expected)
 	return false
 }
 
 func (s *Server) parseJSON(object interface{}, writer http.ResponseWriter, request *http.Request) bool {
-	s.logger.Info("parsing request data")
-	body := make([]byte, request.ContentLength)
-	count, err := request.Body.Read(body)
-	if err != nil && err != io.EOF {
-		s.logger.Error("failed to read request's body: ", err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
+	s.log(request).Info("parsing request data")
+	if request.Body == nil {
+		s.log(request).Error("missing request body")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_json, "", "missing request body")
 		return false
-	} else if count != len(body) {
-		s.logger.Error("failed to fully read request's body")
-		writer.WriteHeader(http.StatusInternalServerError)
+	}
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		s.log(request).Error("failed to read request's body: ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_request_read_failed, "", "failed to read request body")
 		return false
 	}
 	err = json.Unmarshal(body, object)
 	if err != nil {
-		s.logger.Error("failed to parse JSON: ", err.Error())
-		writer.WriteHeader(http.StatusBadRequest)
-		writer.Write(([]byte)("bad JSON"))
+		s.log(request).Error("failed to parse JSON: ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_json, "", err.Error())
 		return false
 	}
 
 	return true
 }
 
-func (s *Server) writeDBResponse(err error, writer http.ResponseWriter) bool {
-	switch err {
-	case database.ErrInvalidData:
-		writer.WriteHeader(http.StatusBadRequest)
-		writer.Write(([]byte)("group and/or song name is empty"))
-	case database.ErrGroupNotFound:
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write(([]byte)("non-existent group"))
-	case database.ErrSongNotFound:
-		writer.WriteHeader(http.StatusNotFound)
-		writer.Write(([]byte)("non-existent song"))
-	case database.ErrPageOutOfBounds:
-		writer.WriteHeader(http.StatusBadRequest)
-		writer.Write(([]byte)("page out of bounds"))
-	case nil:
+func (s *Server) writeJSON(object any, status int, writer http.ResponseWriter, request *http.Request) bool {
+	// HTML escaping is disabled to keep search snippet highlighting readable
+	result := bytes.Buffer{}
+	encoder := json.NewEncoder(&result)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(object)
+	if err != nil {
+		s.log(request).Error("failed to encode response as JSON: ", err.Error())
+		s.writeInternalError(writer, request)
+		return false
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, err = writer.Write(result.Bytes())
+	if err != nil {
+		s.log(request).Error("failed to write response: ", err.Error())
+		return false
+	}
+	return true
+}
+
+// writeDBResponse writes 200 if err is nil and a problem document describing err otherwise.
+func (s *Server) writeDBResponse(err error, writer http.ResponseWriter, request *http.Request) bool {
+	if err == nil {
 		writer.WriteHeader(http.StatusOK)
 		return true
-	default:
-		writer.WriteHeader(http.StatusInternalServerError)
+	}
+	if known, found := dbProblems[err]; found {
+		s.writeProblem(writer, request, known.status, known.code, known.field, known.detail)
+	} else {
+		s.writeInternalError(writer, request)
 	}
 	return false
 }
 
-func (s *Server) parseUintGetParam(query url.Values, key string, writer http.ResponseWriter) (uint, bool) {
+func (s *Server) parseUintGetParam(query url.Values, key string, writer http.ResponseWriter, request *http.Request) (uint, bool) {
 	if len(query[key]) != 1 {
-		s.logger.Error("expected a single value for ", key, " get parameter, got: ", len(query[key]))
-		writer.WriteHeader(http.StatusBadRequest)
+		s.log(request).Error("expected a single value for ", key, " get parameter, got: ", len(query[key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected a single value")
 		return 0, false
 	}
 
 	val, err := strconv.ParseUint(query[key][0], 10, 32)
 	if err != nil {
-		s.logger.Error("failed to parse ", key, ": ", err.Error())
-		writer.WriteHeader(http.StatusBadRequest)
+		s.log(request).Error("failed to parse ", key, ": ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, key, "expected an unsigned integer")
 		return 0, false
 	}
 	return uint(val), true
 }
 
-func (s *Server) getPageIdxAndSize(query url.Values, writer http.ResponseWriter) (idx, size uint, success bool) {
+func (s *Server) getPageIdxAndSize(query url.Values, writer http.ResponseWriter, request *http.Request) (idx, size uint, success bool) {
 	size = default_page_size
 	if len(query[page_size_key]) != 0 {
-		size, success = s.parseUintGetParam(query, page_size_key, writer)
+		size, success = s.parseUintGetParam(query, page_size_key, writer, request)
 		if !success {
 			return 0, 0, false
 		}
 	}
 	if len(query[page_idx_key]) != 0 {
-		idx, success = s.parseUintGetParam(query, page_idx_key, writer)
+		idx, success = s.parseUintGetParam(query, page_idx_key, writer, request)
 		if !success {
 			return 0, 0, false
 		}
@@ -420,19 +484,45 @@ func (s *Server) getPageIdxAndSize(query url.Values, writer http.ResponseWriter)
 	return idx, size, true
 }
 
-func (s *Server) getSongAndGroup(query url.Values, writer http.ResponseWriter) (song, group string, err error) {
+// getCursorAndCount parses the parameters of the cursor pagination, which can't be mixed with page indexes.
+func (s *Server) getCursorAndCount(query url.Values, writer http.ResponseWriter, request *http.Request) (cursor string, with_count bool, success bool) {
+	if query.Has(page_idx_key) {
+		s.log(request).Error(page_idx_key, " and ", cursor_key, " get parameters are mutually exclusive")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, page_idx_key, "page index can't be used with a cursor")
+		return "", false, false
+	} else if len(query[cursor_key]) != 1 {
+		s.log(request).Error("expected a single value for ", cursor_key, " get parameter, got ", len(query[cursor_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, cursor_key, "expected a single value")
+		return "", false, false
+	}
+	if len(query[count_key]) > 1 {
+		s.log(request).Error("expected a single value for ", count_key, " get parameter, got ", len(query[count_key]))
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, count_key, "expected a single value")
+		return "", false, false
+	} else if len(query[count_key]) == 1 {
+		var err error
+		if with_count, err = strconv.ParseBool(query[count_key][0]); err != nil {
+			s.log(request).Error("failed to parse ", count_key, ": ", err.Error())
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, count_key, "expected true or false")
+			return "", false, false
+		}
+	}
+	return query[cursor_key][0], with_count, true
+}
+
+func (s *Server) getSongAndGroup(query url.Values, writer http.ResponseWriter, request *http.Request) (song, group string, err error) {
 	if len(query[song_key]) != 0 {
 		if len(query[song_key]) != 1 {
-			s.logger.Error("expected a single value for ", song_key, " get paramenter, got ", len(query[song_key]))
-			writer.WriteHeader(http.StatusBadRequest)
+			s.log(request).Error("expected a single value for ", song_key, " get paramenter, got ", len(query[song_key]))
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, song_key, "expected a single value")
 			return "", "", ErrWrongArgument
 		}
 		song = query[song_key][0]
 	}
 	if len(query[group_key]) != 0 {
 		if len(query[group_key]) != 1 {
-			s.logger.Error("expected a single value for ", group_key, " get paramenter, got ", len(query[group_key]))
-			writer.WriteHeader(http.StatusBadRequest)
+			s.log(request).Error("expected a single value for ", group_key, " get paramenter, got ", len(query[group_key]))
+			s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, group_key, "expected a single value")
 			return "", "", ErrWrongArgument
 		}
 		group = query[group_key][0]
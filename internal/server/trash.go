@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+const (
+	trash_path         = "/trash"
+	trash_restore_path = "/trash/restore"
+)
+
+func (s *Server) getTrash(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received trash retrieval request")
+	page_idx, page_size, success := s.getPageIdxAndSize(request.URL.Query(), writer, request)
+	if !success {
+		return
+	}
+	result, err := s.db.GetTrash(request.Context(), page_idx, page_size)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) restoreSong(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received request to restore a deleted song")
+	song := database.LibraryEntry{}
+	if !s.parseJSON(&song, writer, request) {
+		return
+	}
+	if s.writeDBResponse(s.db.RestoreSong(request.Context(), song), writer, request) {
+		s.log(request).Info("success")
+	}
+}
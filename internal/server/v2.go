@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+)
+
+const (
+	v2_groups_path = "/v2/groups"
+	v2_song_path   = "/v2/groups/{group}/songs/{song}"
+	v2_verse_path  = "/v2/groups/{group}/songs/{song}/verses/{n}"
+
+	v2_revisions_path = "/v2/groups/{group}/songs/{song}/revisions"
+	v2_revision_path  = "/v2/groups/{group}/songs/{song}/revisions/{revision}"
+	v2_diff_path      = "/v2/groups/{group}/songs/{song}/revisions/diff"
+	v2_restore_path   = "/v2/groups/{group}/songs/{song}/revisions/{revision}/restore"
+
+	group_path_key = "group"
+	song_path_key  = "song"
+	verse_path_key = "n"
+	revision_key   = "revision"
+	diff_from_key  = "from"
+	diff_to_key    = "to"
+)
+
+// registerV2 adds the resource-oriented API.
+func (s *Server) registerV2(mux *http.ServeMux) {
+	s.handle(mux, http.MethodGet+" "+v2_groups_path, database.RoleReader, s.getAll)
+	s.handle(mux, http.MethodPost+" "+v2_groups_path, database.RoleEditor, s.createSongV2)
+	s.handle(mux, http.MethodGet+" "+v2_song_path, database.RoleReader, s.getSongV2)
+	s.handle(mux, http.MethodPut+" "+v2_song_path, database.RoleEditor, s.putSongV2)
+	s.handle(mux, http.MethodPatch+" "+v2_song_path, database.RoleEditor, s.patchSongV2)
+	s.handle(mux, http.MethodDelete+" "+v2_song_path, database.RoleAdmin, s.deleteSongV2)
+	s.handle(mux, http.MethodGet+" "+v2_verse_path, database.RoleReader, s.getVerseV2)
+	mux.HandleFunc(v2_groups_path, s.methodNotAllowed("GET, POST"))
+	mux.HandleFunc(v2_song_path, s.methodNotAllowed("GET, PUT, PATCH, DELETE"))
+	mux.HandleFunc(v2_verse_path, s.methodNotAllowed("GET"))
+	s.handle(mux, http.MethodGet+" "+v2_revisions_path, database.RoleReader, s.getRevisionsV2)
+	s.handle(mux, http.MethodGet+" "+v2_revision_path, database.RoleReader, s.getRevisionV2)
+	s.handle(mux, http.MethodGet+" "+v2_diff_path, database.RoleReader, s.diffRevisionsV2)
+	s.handle(mux, http.MethodPost+" "+v2_restore_path, database.RoleEditor, s.restoreRevisionV2)
+	mux.HandleFunc(v2_revisions_path, s.methodNotAllowed("GET"))
+	mux.HandleFunc(v2_revision_path, s.methodNotAllowed("GET"))
+	mux.HandleFunc(v2_restore_path, s.methodNotAllowed("POST"))
+	s.registerAlbums(mux)
+}
+
+func songLocation(group, song string) string {
+	return v2_groups_path + "/" + url.PathEscape(group) + "/songs/" + url.PathEscape(song)
+}
+
+func songFromPath(request *http.Request) database.LibraryEntry {
+	return database.LibraryEntry{Group: request.PathValue(group_path_key), Song: request.PathValue(song_path_key)}
+}
+
+func (s *Server) parseDate(date string, writer http.ResponseWriter, request *http.Request) (*time.Time, bool) {
+	if date == "" {
+		return nil, true
+	}
+	date_val, err := time.Parse(database.DateFmt, date)
+	if err != nil {
+		s.log(request).Error("failed to parse release date: ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, release_date_key, "expected a date in DD.MM.YYYY format")
+		return nil, false
+	}
+	return &date_val, true
+}
+
+func (s *Server) createSongV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to add a song to the library")
+	song := database.Song{}
+	if !s.parseJSON(&song, writer, request) {
+		return
+	}
+	if song.Group == "" || song.Song == "" {
+		s.writeDBResponse(database.ErrInvalidData, writer, request)
+		return
+	}
+
+	// song details are requested from the song info service unless the client provided all of them
+	var date time.Time
+	if song.Text == "" || song.URL == "" || song.ReleaseDate == "" {
+		song_data, fetched_date, err := s.song_info.Get(request.Context(), song.Group, song.Song)
+		if err != nil {
+			s.writeSongInfoError(err, http.StatusBadGateway, writer, request)
+			return
+		}
+		song.Text, song.URL, song.ReleaseDate = song_data.Text, song_data.URL, song_data.ReleaseDate
+		date = fetched_date
+	} else {
+		date_ptr, success := s.parseDate(song.ReleaseDate, writer, request)
+		if !success {
+			return
+		}
+		date = *date_ptr
+	}
+
+	if err := s.db.AddSong(request.Context(), song.Group, song.Song, song.Text, song.URL, date, song.Artists, requestAuthor(request)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	// the song is returned with all of its credits
+	created, err := s.db.GetSong(request.Context(), song.Group, song.Song)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.Header().Set("Location", songLocation(song.Group, song.Song))
+	if s.writeJSON(created, http.StatusCreated, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+func (s *Server) getSongV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 song retrieval request")
+	entry := songFromPath(request)
+	song, err := s.db.GetSong(request.Context(), entry.Group, entry.Song)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	if s.writeJSON(song, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
+
+// putSongV2 replaces song details, creating the song if it does not exist.
+func (s *Server) putSongV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to replace song details")
+	entry := songFromPath(request)
+	data := songData{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	if data.Text == "" || data.URL == "" || data.ReleaseDate == "" {
+		s.log(request).Error("text, url and release date are required")
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_data, "", "text, url and release_date are required")
+		return
+	}
+	date, success := s.parseDate(data.ReleaseDate, writer, request)
+	if !success {
+		return
+	}
+
+	err := s.db.UpdateSong(request.Context(), entry, "", "", data.Text, data.URL, date, data.Artists, requestAuthor(request))
+	if err == database.ErrSongNotFound {
+		err = s.db.AddSong(request.Context(), entry.Group, entry.Song, data.Text, data.URL, *date, data.Artists, requestAuthor(request))
+		if err != nil {
+			s.writeDBResponse(err, writer, request)
+			return
+		}
+		writer.Header().Set("Location", songLocation(entry.Group, entry.Song))
+		writer.WriteHeader(http.StatusCreated)
+		s.log(request).Info("success")
+		return
+	} else if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+func (s *Server) patchSongV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to update song details")
+	entry := songFromPath(request)
+	data := database.Song{}
+	if !s.parseJSON(&data, writer, request) {
+		return
+	}
+	date, success := s.parseDate(data.ReleaseDate, writer, request)
+	if !success {
+		return
+	}
+
+	// UpdateSong does not report missing songs when there is nothing to update
+	if data.Group == "" && data.Song == "" && data.Text == "" && data.URL == "" && date == nil && data.Artists == nil {
+		if _, err := s.db.GetSong(request.Context(), entry.Group, entry.Song); err != nil {
+			s.writeDBResponse(err, writer, request)
+			return
+		}
+	} else if err := s.db.UpdateSong(request.Context(), entry, data.Group, data.Song, data.Text, data.URL, date, data.Artists, requestAuthor(request)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+
+	if data.Group != "" || data.Song != "" {
+		new_group, new_song := entry.Group, entry.Song
+		if data.Group != "" {
+			new_group = data.Group
+		}
+		if data.Song != "" {
+			new_song = data.Song
+		}
+		writer.Header().Set("Location", songLocation(new_group, new_song))
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+func (s *Server) deleteSongV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 request to delete song")
+	if err := s.db.DeleteSong(request.Context(), songFromPath(request)); err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	s.log(request).Info("success")
+}
+
+func (s *Server) getVerseV2(writer http.ResponseWriter, request *http.Request) {
+	s.log(request).Info("received v2 verse retrieval request")
+	entry := songFromPath(request)
+	verse_idx, err := strconv.ParseUint(request.PathValue(verse_path_key), 10, 32)
+	if err != nil {
+		s.log(request).Error("failed to parse verse index: ", err.Error())
+		s.writeProblem(writer, request, http.StatusBadRequest, code_invalid_argument, verse_path_key, "expected an unsigned integer")
+		return
+	}
+
+	text, err := s.db.GetSongText(request.Context(), entry.Group, entry.Song)
+	if err != nil {
+		s.writeDBResponse(err, writer, request)
+		return
+	}
+	verses := strings.Split(text, "\n\n")
+	if verse_idx >= uint64(len(verses)) {
+		s.log(request).Error("verse index out of bounds, size: ", len(verses), ", index: ", verse_idx)
+		s.writeProblem(writer, request, http.StatusNotFound, code_verse_not_found, verse_path_key, "verse not found")
+		return
+	}
+
+	result := songTextResponse{PageIndex: int(verse_idx), PageCount: len(verses), Verse: verses[verse_idx]}
+	if s.writeJSON(result, http.StatusOK, writer, request) {
+		s.log(request).Info("success")
+	}
+}
@@ -0,0 +1,316 @@
+package songinfo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/database"
+	"github.com/Onlymiind/test_task/internal/logger"
+	"github.com/Onlymiind/test_task/internal/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	info_path   = "/info"
+	tracer_name = "github.com/Onlymiind/test_task/internal/songinfo"
+
+	DefaultTimeout          = 5 * time.Second
+	DefaultMaxRetries       = 3
+	DefaultBaseBackoff      = 100 * time.Millisecond
+	DefaultMaxBackoff       = 2 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+var (
+	ErrCircuitOpen   = fmt.Errorf("song info service circuit is open")
+	ErrBadResponse   = fmt.Errorf("invalid song info response")
+	ErrUpstreamError = fmt.Errorf("song info service error")
+)
+
+type SongData struct {
+	Text        string `json:"text"`
+	ReleaseDate string `json:"release_date"`
+	URL         string `json:"url"`
+}
+
+// Config holds client settings. Zero values are replaced with the defaults above.
+type Config struct {
+	URL     string
+	Timeout time.Duration
+	// MaxRetries is the number of additional attempts after a failed one.
+	MaxRetries  uint
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BreakerThreshold is the number of consecutive failed attempts that opens the circuit.
+	BreakerThreshold uint
+	// BreakerCooldown is the time the circuit stays open before a trial request is let through.
+	BreakerCooldown time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a consecutive failures circuit breaker.
+type breaker struct {
+	mutex     sync.Mutex
+	state     breakerState
+	failures  uint
+	opened_at time.Time
+	threshold uint
+	cooldown  time.Duration
+}
+
+// allow reports whether a request may be sent. In the half-open state only one trial request is allowed.
+func (b *breaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.opened_at) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *breaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed attempt and reports whether the circuit has been opened by it.
+func (b *breaker) failure() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		opened := b.state != breakerOpen
+		b.state = breakerOpen
+		b.opened_at = time.Now()
+		return opened
+	}
+	return false
+}
+
+// retryAfter returns the time left until the circuit lets a trial request through.
+func (b *breaker) retryAfter() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state != breakerOpen {
+		return 0
+	}
+	return max(b.cooldown-time.Since(b.opened_at), 0)
+}
+
+type Client struct {
+	base_url     string
+	http_client  *http.Client
+	max_retries  uint
+	base_backoff time.Duration
+	max_backoff  time.Duration
+	breaker      *breaker
+	logger       *logger.Logger
+}
+
+func NewClient(cfg Config, logger *logger.Logger) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = DefaultBaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = DefaultBreakerCooldown
+	}
+	// the transport creates a span for every attempt and propagates the trace context to the upstream
+	return &Client{
+		base_url:     strings.TrimSuffix(cfg.URL, "/"),
+		http_client:  &http.Client{Timeout: cfg.Timeout, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		max_retries:  cfg.MaxRetries,
+		base_backoff: cfg.BaseBackoff,
+		max_backoff:  cfg.MaxBackoff,
+		breaker:      &breaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown},
+		logger:       logger,
+	}
+}
+
+// RetryAfter returns the time left until the open circuit lets requests through again.
+func (c *Client) RetryAfter() time.Duration {
+	return c.breaker.retryAfter()
+}
+
+// backoff returns the delay before the given retry: a random value between zero
+// and the exponentially growing cap ("full jitter").
+func (c *Client) backoff(retry uint) time.Duration {
+	limit := c.max_backoff
+	// compared before shifting, so that the shift can't overflow
+	if retry < 63 && c.base_backoff <= c.max_backoff>>retry {
+		limit = c.base_backoff << retry
+	}
+	return rand.N(limit) + 1
+}
+
+// log returns the request-scoped logger from the context or the default one.
+func (c *Client) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, c.logger)
+}
+
+// Ready checks that the service is reachable. Any HTTP response counts, the request
+// has no parameters and so isn't expected to succeed. The check doesn't affect the circuit breaker.
+func (c *Client) Ready(ctx context.Context) error {
+	if c.breaker.retryAfter() > 0 {
+		return ErrCircuitOpen
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base_url+info_path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.http_client.Do(request)
+	if err != nil {
+		c.log(ctx).Error("song info service is unreachable: ", err.Error())
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Get requests details of the song, retrying on network errors and 5xx responses.
+func (c *Client) Get(ctx context.Context, group, song string) (SongData, time.Time, error) {
+	ctx, span := otel.Tracer(tracer_name).Start(ctx, "song info lookup", trace.WithAttributes(
+		attribute.String("song.group", group), attribute.String("song.name", song)))
+	defer span.End()
+
+	start := time.Now()
+	data, date, err := c.getWithRetries(ctx, group, song)
+	metrics.ObserveSongInfo(time.Since(start), err)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return data, date, err
+}
+
+func (c *Client) getWithRetries(ctx context.Context, group, song string) (SongData, time.Time, error) {
+	get_params := url.Values{"group": {group}, "name": {song}}
+	request_url := c.base_url + info_path + "?" + get_params.Encode()
+
+	var err error
+	for attempt := uint(0); attempt <= c.max_retries; attempt++ {
+		if attempt != 0 {
+			delay := c.backoff(attempt - 1)
+			c.log(ctx).Info("retrying song info request in ", delay, ", attempt ", attempt+1)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return SongData{}, time.Time{}, ctx.Err()
+			}
+		}
+		if !c.breaker.allow() {
+			c.log(ctx).Error(ErrCircuitOpen.Error())
+			return SongData{}, time.Time{}, ErrCircuitOpen
+		}
+
+		var data SongData
+		var date time.Time
+		var retryable bool
+		data, date, retryable, err = c.get(ctx, request_url)
+		if err == nil {
+			c.breaker.success()
+			return data, date, nil
+		} else if !retryable {
+			// the upstream is reachable, it's the response that is wrong
+			c.breaker.success()
+			return SongData{}, time.Time{}, err
+		}
+		// the caller gave up, which says nothing about the upstream
+		if ctx.Err() != nil {
+			return SongData{}, time.Time{}, ctx.Err()
+		}
+		if c.breaker.failure() {
+			c.log(ctx).Error("song info service circuit opened")
+		}
+	}
+	return SongData{}, time.Time{}, err
+}
+
+func (c *Client) get(ctx context.Context, request_url string) (data SongData, date time.Time, retryable bool, err error) {
+	c.log(ctx).Info("sending song info request to: ", request_url)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, request_url, nil)
+	if err != nil {
+		c.log(ctx).Error("failed to create song info request: ", err.Error())
+		return SongData{}, time.Time{}, false, err
+	}
+	response, err := c.http_client.Do(request)
+	if err != nil {
+		c.log(ctx).Error("failed to get song info: ", err.Error())
+		return SongData{}, time.Time{}, true, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		c.log(ctx).Error("failed to read response body: ", err.Error())
+		return SongData{}, time.Time{}, true, err
+	}
+	if response.StatusCode >= http.StatusInternalServerError {
+		c.log(ctx).Error("failed to get song info, response status: ", response.Status)
+		return SongData{}, time.Time{}, true, ErrUpstreamError
+	} else if response.StatusCode != http.StatusOK {
+		c.log(ctx).Error("failed to get song info, response status: ", response.Status)
+		return SongData{}, time.Time{}, false, ErrBadResponse
+	}
+	if media_type, _, err := mime.ParseMediaType(response.Header.Get("Content-Type")); err != nil || media_type != "application/json" {
+		c.log(ctx).Error("unexpected content type in response: ", response.Header.Get("Content-Type"))
+		return SongData{}, time.Time{}, false, ErrBadResponse
+	}
+
+	if err = json.Unmarshal(body, &data); err != nil {
+		c.log(ctx).Error("failed to parse the response: ", err.Error())
+		return SongData{}, time.Time{}, false, errors.Join(ErrBadResponse, err)
+	}
+	if data.Text == "" {
+		c.log(ctx).Error("song text empty")
+		return SongData{}, time.Time{}, false, ErrBadResponse
+	} else if data.URL == "" {
+		c.log(ctx).Error("song url empty")
+		return SongData{}, time.Time{}, false, ErrBadResponse
+	}
+	date, err = time.Parse(database.DateFmt, data.ReleaseDate)
+	if err != nil {
+		c.log(ctx).Error("failed to parse release date: ", err.Error())
+		return SongData{}, time.Time{}, false, errors.Join(ErrBadResponse, err)
+	}
+	return data, date, false, nil
+}
@@ -0,0 +1,292 @@
+package songinfo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Onlymiind/test_task/internal/logger"
+)
+
+const song_json = `{"text":"Ooh baby, don't you know I suffer?","release_date":"16.07.2006","url":"https://example.com/smbh"}`
+
+// testServer answers every request with the handler and counts the requests.
+type testServer struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) *testServer {
+	t.Helper()
+	server := &testServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.requests.Add(1)
+		handler(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeSong(writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write([]byte(song_json))
+}
+
+func newTestClient(t *testing.T, cfg Config) *Client {
+	t.Helper()
+	log, err := logger.NewLogger(io.Discard, logger.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BaseBackoff == 0 {
+		cfg.BaseBackoff = time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 5 * time.Millisecond
+	}
+	return NewClient(cfg, log)
+}
+
+func TestGetSuccess(t *testing.T) {
+	server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != info_path || request.URL.Query().Get("group") != "Muse" ||
+			request.URL.Query().Get("name") != "Supermassive Black Hole" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		writeSong(writer)
+	})
+	client := newTestClient(t, Config{URL: server.URL + "/"})
+
+	data, date, err := client.Get(context.Background(), "Muse", "Supermassive Black Hole")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if data.URL != "https://example.com/smbh" || data.ReleaseDate != "16.07.2006" || data.Text == "" {
+		t.Errorf("data = %+v", data)
+	}
+	if !date.Equal(time.Date(2006, 7, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("date = %v", date)
+	}
+}
+
+func TestGetResponseBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		content_type string
+		body         string
+		want         error
+	}{
+		{"charset parameter", http.StatusOK, "application/json; charset=utf-8", song_json, nil},
+		{"wrong content type", http.StatusOK, "text/plain", song_json, ErrBadResponse},
+		{"invalid json", http.StatusOK, "application/json", `{"text":`, ErrBadResponse},
+		{"empty text", http.StatusOK, "application/json", `{"release_date":"16.07.2006","url":"u"}`, ErrBadResponse},
+		{"empty url", http.StatusOK, "application/json", `{"text":"t","release_date":"16.07.2006"}`, ErrBadResponse},
+		{"invalid date", http.StatusOK, "application/json", `{"text":"t","release_date":"2006-07-16","url":"u"}`, ErrBadResponse},
+		{"not found", http.StatusNotFound, "application/json", `{}`, ErrBadResponse},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+				writer.Header().Set("Content-Type", test.content_type)
+				writer.WriteHeader(test.status)
+				writer.Write([]byte(test.body))
+			})
+			client := newTestClient(t, Config{URL: server.URL, MaxRetries: 3})
+
+			_, _, err := client.Get(context.Background(), "Muse", "Uprising")
+			if !errors.Is(err, test.want) {
+				t.Errorf("error = %v, want %v", err, test.want)
+			}
+			// invalid responses are not retried
+			if requests := server.requests.Load(); requests != 1 {
+				t.Errorf("requests = %d, want 1", requests)
+			}
+		})
+	}
+}
+
+func TestGetRetries(t *testing.T) {
+	server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusBadGateway)
+	})
+	client := newTestClient(t, Config{URL: server.URL, MaxRetries: 2})
+
+	if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrUpstreamError) {
+		t.Errorf("error = %v, want ErrUpstreamError", err)
+	}
+	if requests := server.requests.Load(); requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestGetRecoversAfterRetry(t *testing.T) {
+	var server *testServer
+	server = newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		if server.requests.Load() < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeSong(writer)
+	})
+	client := newTestClient(t, Config{URL: server.URL, MaxRetries: 3})
+
+	if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if requests := server.requests.Load(); requests != 3 {
+		t.Errorf("requests = %d, want 3", requests)
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-request.Context().Done():
+		}
+	})
+	client := newTestClient(t, Config{URL: server.URL, Timeout: 20 * time.Millisecond, MaxRetries: 1})
+
+	start := time.Now()
+	if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); err == nil {
+		t.Fatal("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Get took %v", elapsed)
+	}
+	if requests := server.requests.Load(); requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := newTestClient(t, Config{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond})
+	tests := []struct {
+		retry uint
+		limit time.Duration
+	}{
+		{0, 10 * time.Millisecond},
+		{1, 20 * time.Millisecond},
+		{3, 80 * time.Millisecond},
+		{4, 100 * time.Millisecond},
+		{40, 100 * time.Millisecond},
+		{63, 100 * time.Millisecond},
+		{1000, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		for range 100 {
+			if delay := client.backoff(test.retry); delay <= 0 || delay > test.limit {
+				t.Fatalf("backoff(%d) = %v, want (0, %v]", test.retry, delay, test.limit)
+			}
+		}
+	}
+
+	// a base above the maximum is capped
+	capped := newTestClient(t, Config{BaseBackoff: time.Second, MaxBackoff: time.Millisecond})
+	if delay := capped.backoff(0); delay <= 0 || delay > time.Millisecond {
+		t.Errorf("backoff = %v", delay)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	})
+	client := newTestClient(t, Config{URL: server.URL, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+
+	for range 2 {
+		if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrUpstreamError) {
+			t.Fatalf("error = %v, want ErrUpstreamError", err)
+		}
+	}
+	if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error = %v, want ErrCircuitOpen", err)
+	}
+	if requests := server.requests.Load(); requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+	if retry_after := client.RetryAfter(); retry_after <= 0 || retry_after > time.Hour {
+		t.Errorf("RetryAfter = %v", retry_after)
+	}
+	if err := client.Ready(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Ready = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	var healthy atomic.Bool
+	server := newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		if !healthy.Load() {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeSong(writer)
+	})
+	cooldown := 30 * time.Millisecond
+	client := newTestClient(t, Config{URL: server.URL, BreakerThreshold: 1, BreakerCooldown: cooldown})
+	get := func() error {
+		_, _, err := client.Get(context.Background(), "Muse", "Uprising")
+		return err
+	}
+
+	if err := get(); !errors.Is(err, ErrUpstreamError) {
+		t.Fatalf("error = %v, want ErrUpstreamError", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want ErrCircuitOpen", err)
+	}
+
+	// a failed trial request opens the circuit again
+	time.Sleep(cooldown)
+	if err := get(); !errors.Is(err, ErrUpstreamError) {
+		t.Fatalf("trial error = %v, want ErrUpstreamError", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error = %v, want ErrCircuitOpen", err)
+	}
+
+	// only one trial request is let through, a successful one closes the circuit
+	time.Sleep(cooldown)
+	if !client.breaker.allow() {
+		t.Fatal("trial request not allowed after the cooldown")
+	} else if client.breaker.allow() {
+		t.Fatal("second request allowed while half-open")
+	}
+	healthy.Store(true)
+	client.breaker.success()
+	for range 3 {
+		if err := get(); err != nil {
+			t.Fatalf("Get after recovery: %v", err)
+		}
+	}
+}
+
+func TestCallerCancellationDoesNotOpenBreaker(t *testing.T) {
+	var server *testServer
+	server = newTestServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		if server.requests.Load() == 1 {
+			<-request.Context().Done()
+			return
+		}
+		writeSong(writer)
+	})
+	client := newTestClient(t, Config{URL: server.URL, MaxRetries: 3, BreakerThreshold: 1, BreakerCooldown: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.Get(ctx, "Muse", "Uprising"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if retry_after := client.RetryAfter(); retry_after != 0 {
+		t.Errorf("circuit opened by the caller deadline, RetryAfter = %v", retry_after)
+	}
+	if _, _, err := client.Get(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Errorf("Get after the caller deadline: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package sqlbuilder
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Builder accumulates the SET columns and WHERE conditions of a query together with their
+// arguments, so that placeholders always match the argument positions whatever combination
+// of clauses is added. Values are never written into the SQL text.
+type Builder struct {
+	sets       []string
+	conditions []string
+	args       []any
+}
+
+// Param adds an argument and returns its placeholder.
+func (b *Builder) Param(value any) string {
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// Args returns the arguments in placeholder order.
+func (b *Builder) Args() []any {
+	return b.args
+}
+
+// Set adds a column assignment for Update.
+func (b *Builder) Set(column string, value any) {
+	b.sets = append(b.sets, column+" = "+b.Param(value))
+}
+
+// Where adds a condition, all conditions must hold.
+func (b *Builder) Where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+// And returns the conditions to append to a query that already has a WHERE clause.
+func (b *Builder) And() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(b.conditions, " AND ")
+}
+
+// Update returns the UPDATE statement for the table. At least one column must be set.
+func (b *Builder) Update(table string) string {
+	query := "UPDATE " + table + " SET " + strings.Join(b.sets, ", ")
+	if len(b.conditions) != 0 {
+		query += " WHERE " + strings.Join(b.conditions, " AND ")
+	}
+	return query + ";"
+}
+
+// Or joins the conditions so that any of them must hold.
+func Or(conditions ...string) string {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// EscapeLike makes the value match itself in a LIKE pattern with the default escape character.
+func EscapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
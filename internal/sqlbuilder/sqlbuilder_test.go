@@ -0,0 +1,114 @@
+package sqlbuilder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder) string
+		sql   string
+		args  []any
+	}{
+		{
+			name:  "param",
+			build: func(b *Builder) string { return b.Param(1) + ", " + b.Param("a") },
+			sql:   "$1, $2",
+			args:  []any{1, "a"},
+		},
+		{
+			name:  "and without conditions",
+			build: func(b *Builder) string { return "SELECT 1 WHERE true" + b.And() },
+			sql:   "SELECT 1 WHERE true",
+		},
+		{
+			name: "and",
+			build: func(b *Builder) string {
+				b.Where("a = " + b.Param(1))
+				b.Where("b = " + b.Param(2))
+				return "SELECT 1 WHERE true" + b.And()
+			},
+			sql:  "SELECT 1 WHERE true AND a = $1 AND b = $2",
+			args: []any{1, 2},
+		},
+		{
+			name: "update",
+			build: func(b *Builder) string {
+				b.Set("a", "x")
+				b.Set("b", "y")
+				return b.Update("t")
+			},
+			sql:  "UPDATE t SET a = $1, b = $2;",
+			args: []any{"x", "y"},
+		},
+		{
+			name: "update with conditions",
+			build: func(b *Builder) string {
+				b.Set("a", "x")
+				b.Where("id = " + b.Param(7))
+				b.Where("deleted_at IS NULL")
+				return b.Update("t")
+			},
+			sql:  "UPDATE t SET a = $1 WHERE id = $2 AND deleted_at IS NULL;",
+			args: []any{"x", 7},
+		},
+		{
+			name: "placeholders follow the call order",
+			build: func(b *Builder) string {
+				b.Where("id = " + b.Param(7))
+				b.Set("a", "x")
+				b.Where(Or("c = "+b.Param(1), "c = "+b.Param(2)))
+				return b.Update("t")
+			},
+			sql:  "UPDATE t SET a = $2 WHERE id = $1 AND (c = $3 OR c = $4);",
+			args: []any{7, "x", 1, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := Builder{}
+			if sql := test.build(&b); sql != test.sql {
+				t.Errorf("sql = %q, want %q", sql, test.sql)
+			}
+			if !reflect.DeepEqual(b.Args(), test.args) {
+				t.Errorf("args = %v, want %v", b.Args(), test.args)
+			}
+		})
+	}
+}
+
+func TestOr(t *testing.T) {
+	tests := []struct {
+		conditions []string
+		want       string
+	}{
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b"}, "(a OR b)"},
+		{[]string{"a", "b", "c"}, "(a OR b OR c)"},
+	}
+	for _, test := range tests {
+		if got := Or(test.conditions...); got != test.want {
+			t.Errorf("Or(%q) = %q, want %q", test.conditions, got, test.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"abc", "abc"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\b`, `a\\b`},
+		{`%_\`, `\%\_\\`},
+	}
+	for _, test := range tests {
+		if got := EscapeLike(test.value); got != test.want {
+			t.Errorf("EscapeLike(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Onlymiind/test_task/internal/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+
+	ServiceName = "songs"
+
+	DefaultOTLPEndpoint = "localhost:4318"
+)
+
+var ErrUnknownExporter = fmt.Errorf("unknown trace exporter")
+
+type Config struct {
+	// Exporter is one of ExporterNone (default), ExporterOTLP or ExporterStdout
+	Exporter string
+	// OTLPEndpoint is the host:port of the collector OTLP/HTTP receiver
+	OTLPEndpoint string
+	// File receives spans from the stdout exporter, os.Stdout is used if empty
+	File string
+}
+
+// Init installs the global tracer provider and the W3C trace context propagator.
+// The returned function flushes the spans that haven't been exported yet and must be called on exit.
+func Init(config Config, logger *logger.Logger) (func(context.Context) error, error) {
+	// the propagator is installed even if tracing is disabled, so that an upstream trace context
+	// is still passed to the song info service
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var output io.Closer
+	var err error
+	switch config.Exporter {
+	case "", ExporterNone:
+		logger.Info("tracing is disabled")
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		endpoint := config.OTLPEndpoint
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		logger.Info("exporting traces to OTLP collector at ", endpoint)
+	case ExporterStdout:
+		writer := io.Writer(os.Stdout)
+		if config.File != "" {
+			var file *os.File
+			if file, err = os.OpenFile(config.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+				writer, output = file, file
+			}
+		}
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+		}
+		if config.File == "" {
+			logger.Info("exporting traces to stdout")
+		} else {
+			logger.Info("exporting traces to ", config.File)
+		}
+	default:
+		err = ErrUnknownExporter
+	}
+	if err != nil {
+		logger.Error("failed to create trace exporter '", config.Exporter, "': ", err.Error())
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			logger.Error("failed to flush traces: ", err.Error())
+		}
+		if output != nil {
+			output.Close()
+		}
+		return err
+	}, nil
+}
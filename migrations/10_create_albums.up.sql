@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS albums
+	(id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE, title TEXT NOT NULL,
+	release_date date NOT NULL, cover_url TEXT NOT NULL DEFAULT '',
+	CONSTRAINT unique_album UNIQUE(group_id, title));
+CREATE TABLE IF NOT EXISTS album_tracks
+	(album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+	song_id INTEGER NOT NULL UNIQUE REFERENCES songs(id) ON DELETE CASCADE,
+	track_number INTEGER NOT NULL CHECK (track_number > 0),
+	inherit_release_date BOOLEAN NOT NULL DEFAULT false,
+	PRIMARY KEY (album_id, track_number));
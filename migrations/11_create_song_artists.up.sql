@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS song_artists
+	(song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+	group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+	role TEXT NOT NULL CHECK (role IN ('primary', 'featured', 'composer', 'lyricist')),
+	PRIMARY KEY (song_id, group_id, role));
+CREATE INDEX IF NOT EXISTS song_artists_group_idx ON song_artists(group_id);
+INSERT INTO song_artists(song_id, group_id, role) SELECT id, group_id, 'primary' FROM songs
+	ON CONFLICT DO NOTHING;
+ALTER TABLE ingestion_jobs ADD COLUMN IF NOT EXISTS artists JSONB NOT NULL DEFAULT '[]';
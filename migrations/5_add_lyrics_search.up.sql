@@ -0,0 +1,3 @@
+ALTER TABLE song_info ADD COLUMN IF NOT EXISTS lyrics_tsv tsvector
+	GENERATED ALWAYS AS (to_tsvector('simple', lyrics)) STORED;
+CREATE INDEX IF NOT EXISTS song_info_lyrics_tsv_idx ON song_info USING GIN (lyrics_tsv);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS ingestion_jobs
+	(id TEXT PRIMARY KEY, group_name TEXT NOT NULL, song_name TEXT NOT NULL,
+	status TEXT NOT NULL, error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now());
+CREATE INDEX IF NOT EXISTS ingestion_jobs_pending_idx ON ingestion_jobs(created_at)
+	WHERE status IN ('queued', 'running');
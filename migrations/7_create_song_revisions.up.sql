@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS song_revisions
+	(song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE, revision INTEGER NOT NULL,
+	author TEXT NOT NULL DEFAULT '', created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	prev_group TEXT, prev_name TEXT, prev_lyrics TEXT, prev_url TEXT, prev_release_date date,
+	group_name TEXT NOT NULL, song_name TEXT NOT NULL, lyrics TEXT NOT NULL, url TEXT NOT NULL, release_date date NOT NULL,
+	PRIMARY KEY (song_id, revision));
+INSERT INTO song_revisions(song_id, revision, group_name, song_name, lyrics, url, release_date)
+	SELECT songs.id, 1, groups.name, songs.song_name, lyrics, url, release_date
+	FROM groups JOIN songs ON groups.id = songs.group_id JOIN song_info ON songs.id = song_info.song_id
+	ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE songs DROP CONSTRAINT IF EXISTS fk_unique_song;
+CREATE UNIQUE INDEX IF NOT EXISTS fk_unique_song ON songs(group_id, song_name) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS songs_deleted_at_idx ON songs(deleted_at) WHERE deleted_at IS NOT NULL;